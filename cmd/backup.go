@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chat-app/internal/repo"
+)
+
+const (
+	backupUsage = `usage: chat-app backup [FILE]
+
+  Write a consistent snapshot of the database to FILE, by default a new
+  file in BACKUP_DIR. The server may keep running.`
+
+	restoreUsage = `usage: chat-app restore FILE
+
+  Replace the database with the backup in FILE, once it is checked and
+  migrated. Stop the server first; the replaced database is kept next to
+  it as {file}.pre-restore-{time}.`
+)
+
+// backup runs the backup subcommand against the database chosen by
+// DB_DRIVER and DB_DSN.
+func backup(args []string) error {
+	if len(args) > 1 {
+		return errors.New(backupUsage)
+	}
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		dir := getenv("BACKUP_DIR", defaultBackupDir)
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+		path = filepath.Join(dir, repo.BackupFileName(time.Now()))
+	}
+
+	db, err := openRepo(repo.WithMigrate(false))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Backup(path); err != nil {
+		return err
+	}
+	fmt.Printf("backed up the database to %s\n", path)
+	return nil
+}
+
+// restore runs the restore subcommand against the database chosen by
+// DB_DRIVER and DB_DSN.
+func restore(args []string) error {
+	if len(args) != 1 {
+		return errors.New(restoreUsage)
+	}
+	if getenv("DB_DRIVER", repo.DriverSQLite) != repo.DriverSQLite {
+		return repo.ErrBackupUnsupported
+	}
+	result, err := repo.Restore(getenv("DB_DSN", defaultDSN), args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored the database from %s, taken at schema version %d\n", args[0], result.SchemaVersion)
+	if result.Previous != "" {
+		fmt.Printf("the replaced database is kept as %s\n", result.Previous)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"chat-app/internal/transcript"
+)
+
+const importUsage = `usage: chat-app import ROOM [FILE]
+
+  Store the messages of the JSONL export in FILE, or on the standard input
+  when FILE is missing or "-", in ROOM, creating it when missing.`
+
+// importRoom runs the import subcommand against the database chosen by
+// DB_DRIVER and DB_DSN.
+func importRoom(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New(importUsage)
+	}
+	var in io.Reader = os.Stdin
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	db, err := openRepo()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := transcript.Import(db, args[0], in)
+	fmt.Printf("imported %d messages into room %s, skipped %d already imported\n", result.Imported, result.Room, result.Skipped)
+	return err
+}
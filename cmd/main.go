@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"chat-app/internal/controller"
+	"chat-app/internal/models"
 	"chat-app/internal/repo"
 
 	"github.com/gin-gonic/gin"
@@ -16,8 +26,135 @@ import (
 //	@description	This is a sample server for a chat application.
 //	@host			localhost:8080
 //	@BasePath		/
+//
+//	@securityDefinitions.apikey	BearerAuth
+//	@in							header
+//	@name						Authorization
+//	@description				"Bearer <access token>" as returned by login
+
+const (
+	shutdownTimeout  = 15 * time.Second
+	defaultDSN       = "chat.db"
+	defaultBackupDir = "backups"
+
+	defaultRetentionInterval = 10 * time.Minute
+	defaultEditWindow        = 15 * time.Minute
+	defaultMaxMessageSize    = 4096
+	defaultSendBuffer        = 256
+	defaultRoomIdleTimeout   = 10 * time.Minute
+)
+
+// getenv returns the environment variable key, or fallback when it is unset.
+func getenv(key, fallback string) string {
+	if value, found := os.LookupEnv(key); found && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// retentionPolicy reads the global retention policy and the janitor
+// interval from RETENTION_MAX_AGE, RETENTION_MAX_COUNT, RETENTION_MAX_BYTES
+// and RETENTION_INTERVAL.
+func retentionPolicy() (models.RetentionPolicy, time.Duration) {
+	policy := models.RetentionPolicy{}
+	interval := defaultRetentionInterval
+	var err error
+	if value := os.Getenv("RETENTION_MAX_AGE"); value != "" {
+		if policy.MaxAge, err = time.ParseDuration(value); err != nil {
+			log.Fatalf("Invalid RETENTION_MAX_AGE: %v", err)
+		}
+	}
+	if value := os.Getenv("RETENTION_MAX_COUNT"); value != "" {
+		if policy.MaxCount, err = strconv.ParseInt(value, 10, 64); err != nil {
+			log.Fatalf("Invalid RETENTION_MAX_COUNT: %v", err)
+		}
+	}
+	if value := os.Getenv("RETENTION_MAX_BYTES"); value != "" {
+		if policy.MaxBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			log.Fatalf("Invalid RETENTION_MAX_BYTES: %v", err)
+		}
+	}
+	if value := os.Getenv("RETENTION_INTERVAL"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil {
+			log.Fatalf("Invalid RETENTION_INTERVAL: %v", err)
+		}
+	}
+	return policy, interval
+}
+
+// editWindow reads from EDIT_WINDOW how long after posting a message its
+// author may edit it.
+func editWindow() time.Duration {
+	window, err := time.ParseDuration(getenv("EDIT_WINDOW", defaultEditWindow.String()))
+	if err != nil {
+		log.Fatalf("Invalid EDIT_WINDOW: %v", err)
+	}
+	return window
+}
+
+// maxMessageSize reads from MAX_MESSAGE_SIZE how many bytes the content of a
+// message may take.
+func maxMessageSize() int {
+	size, err := strconv.Atoi(getenv("MAX_MESSAGE_SIZE", strconv.Itoa(defaultMaxMessageSize)))
+	if err != nil {
+		log.Fatalf("Invalid MAX_MESSAGE_SIZE: %v", err)
+	}
+	return size
+}
+
+// sendBuffer reads from SEND_BUFFER how many outbound frames each socket
+// buffers, and from SEND_OVERFLOW what happens to a client that falls further
+// behind: drop_oldest or disconnect.
+func sendBuffer() (int, models.OverflowPolicy) {
+	size, err := strconv.Atoi(getenv("SEND_BUFFER", strconv.Itoa(defaultSendBuffer)))
+	if err != nil {
+		log.Fatalf("Invalid SEND_BUFFER: %v", err)
+	}
+	return size, models.OverflowPolicy(getenv("SEND_OVERFLOW", string(models.OverflowDisconnect)))
+}
+
+// roomIdleTimeout reads from ROOM_IDLE_TIMEOUT how long a room nobody is in
+// stays loaded.
+func roomIdleTimeout() time.Duration {
+	timeout, err := time.ParseDuration(getenv("ROOM_IDLE_TIMEOUT", defaultRoomIdleTimeout.String()))
+	if err != nil {
+		log.Fatalf("Invalid ROOM_IDLE_TIMEOUT: %v", err)
+	}
+	return timeout
+}
+
+// openRepo opens the database chosen by DB_DRIVER and DB_DSN.
+func openRepo(opts ...repo.Option) (*repo.Repo, error) {
+	opts = append([]repo.Option{repo.WithDriver(getenv("DB_DRIVER", repo.DriverSQLite))}, opts...)
+	return repo.NewRepo(getenv("DB_DSN", defaultDSN), opts...)
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importRoom(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to import: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		if err := backup(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to back up: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := restore(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to restore: %v", err)
+		}
+		return
+	}
+
 	r := gin.Default()
 	_, b, _, _ := runtime.Caller(0)
 
@@ -26,13 +163,21 @@ func main() {
 	templatesPath := filepath.Join(projectRoot, "ui", "*.html")
 	r.LoadHTMLGlob(templatesPath)
 
-	repo, err := repo.NewRepo(":memory:")
+	repo, err := openRepo()
 	if err != nil {
 		log.Fatalf("Failed to create repository: %v", err)
 	}
 	ctrl, err := controller.NewController(
 		controller.WithRouter(r),
 		controller.WithRepo(repo),
+		controller.WithTaskStore(repo),
+		controller.WithAdmins(strings.Split(os.Getenv("ADMIN_USERS"), ",")...),
+		controller.WithRetention(retentionPolicy()),
+		controller.WithEditWindow(editWindow()),
+		controller.WithMaxMessageSize(maxMessageSize()),
+		controller.WithSendBuffer(sendBuffer()),
+		controller.WithRoomIdleTimeout(roomIdleTimeout()),
+		controller.WithBackups(repo, getenv("BACKUP_DIR", defaultBackupDir)),
 	)
 	if err != nil {
 		log.Fatalf("Failed to create controller: %v", err)
@@ -40,5 +185,28 @@ func main() {
 
 	ctrl.RegisterRoutes()
 
-	r.Run(":8080")
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("Shutting down")
+
+	// stop accepting requests, then let the sockets and workers wind down
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down the server: %v", err)
+	}
+	if err := ctrl.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down the controller: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		log.Printf("Failed to close the repository: %v", err)
+	}
 }
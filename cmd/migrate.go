@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"chat-app/internal/repo"
+)
+
+const migrateUsage = `usage: chat-app migrate [command]
+
+  up            apply the pending migrations (default)
+  down [steps]  revert the last steps migrations, 1 by default
+  to VERSION    apply or revert migrations until the schema is at VERSION
+  status        list the migrations and when they were applied`
+
+// migrate runs the migrate subcommand against the database chosen by
+// DB_DRIVER and DB_DSN.
+func migrate(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	db, err := openRepo(repo.WithMigrate(false))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case command == "up" && len(args) == 0:
+		err = db.Migrate()
+	case command == "down" && len(args) <= 1:
+		steps := 1
+		if len(args) == 1 {
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[0])
+			}
+		}
+		err = db.Rollback(steps)
+	case command == "to" && len(args) == 1:
+		version, convErr := strconv.Atoi(args[0])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		err = db.MigrateTo(version)
+	case command == "status" && len(args) == 0:
+	default:
+		return fmt.Errorf("unknown command\n%s", migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+	return printMigrationStatus(db)
+}
+
+func printMigrationStatus(db *repo.Repo) error {
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range status {
+		appliedAt := "pending"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"chat-app/pkg/queue"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultDrainTimeout = 10 * time.Second
+
+// RequireAdmin is the middleware restricting the admin API to the users set
+// with WithAdmins; it runs after RequireAuth.
+func (c *Controller) RequireAdmin(ctx *gin.Context) {
+	session := sessionFrom(ctx)
+	if session.Guest || !c.admins[session.Nickname] {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+		return
+	}
+	ctx.Next()
+}
+
+// GetWorkers godoc
+//
+//	@Summary		Get workers
+//	@Description	List the bot and room workers with their queue depth, task counters and last error
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{array}		queue.WorkerStats
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		403	{object}	map[string]string{}
+//	@Router			/api/v1/admin/workers [get]
+func (c *Controller) GetWorkers(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.workers.Stats())
+}
+
+// GetDeadLetters godoc
+//
+//	@Summary		Get dead letters
+//	@Description	List the latest 100 room and bot tasks that used up their retries since the server started, oldest first. Bot commands stay listed by GET /api/v1/admin/tasks across restarts.
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{array}		queue.DeadLetterInfo
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		403	{object}	map[string]string{}
+//	@Router			/api/v1/admin/dead-letters [get]
+func (c *Controller) GetDeadLetters(ctx *gin.Context) {
+	letters := c.deadLetters.Letters()
+	infos := make([]queue.DeadLetterInfo, 0, len(letters))
+	for _, letter := range letters {
+		infos = append(infos, letter.Info())
+	}
+	ctx.JSON(http.StatusOK, infos)
+}
+
+// PauseWorker godoc
+//
+//	@Summary		Pause a worker
+//	@Description	Stop a worker from running tasks; they keep queueing until it is resumed, up to 256, and the ones past that are dropped. Room workers are named "room:{room}".
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			worker	path		string	true	"Worker name"
+//	@Success		200		{object}	queue.WorkerStats
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		403		{object}	map[string]string{}
+//	@Failure		404		{object}	map[string]string{}
+//	@Router			/api/v1/admin/workers/{worker}/pause [post]
+func (c *Controller) PauseWorker(ctx *gin.Context) {
+	if worker, found := c.worker(ctx); found {
+		worker.Pause()
+		ctx.JSON(http.StatusOK, worker.Stats())
+	}
+}
+
+// ResumeWorker godoc
+//
+//	@Summary		Resume a worker
+//	@Description	Let a paused worker run its queued tasks again
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			worker	path		string	true	"Worker name"
+//	@Success		200		{object}	queue.WorkerStats
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		403		{object}	map[string]string{}
+//	@Failure		404		{object}	map[string]string{}
+//	@Router			/api/v1/admin/workers/{worker}/resume [post]
+func (c *Controller) ResumeWorker(ctx *gin.Context) {
+	if worker, found := c.worker(ctx); found {
+		worker.Resume()
+		ctx.JSON(http.StatusOK, worker.Stats())
+	}
+}
+
+// DrainWorker godoc
+//
+//	@Summary		Drain a worker
+//	@Description	Resume a worker and wait until the tasks queued so far, and their retries, are done
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			worker	path		string	true	"Worker name"
+//	@Param			timeout	query		string	false	"How long to wait, as a Go duration (default 10s)"
+//	@Success		200		{object}	queue.WorkerStats
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		403		{object}	map[string]string{}
+//	@Failure		404		{object}	map[string]string{}
+//	@Failure		504		{object}	queue.WorkerStats
+//	@Router			/api/v1/admin/workers/{worker}/drain [post]
+func (c *Controller) DrainWorker(ctx *gin.Context) {
+	timeout := defaultDrainTimeout
+	if value := ctx.Query("timeout"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a positive duration such as 30s"})
+			return
+		}
+		timeout = d
+	}
+
+	worker, found := c.worker(ctx)
+	if !found {
+		return
+	}
+	drainCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+	defer cancel()
+	if err := worker.Drain(drainCtx); err != nil {
+		ctx.JSON(http.StatusGatewayTimeout, worker.Stats())
+		return
+	}
+	ctx.JSON(http.StatusOK, worker.Stats())
+}
+
+// GetTasks godoc
+//
+//	@Summary		Get durable tasks
+//	@Description	List the persisted bot commands in a status, oldest first: the pending ones are queued or waiting for a retry, the failed ones used up their retries and are kept, with their last error, until deleted. The payload is the base64 of the task JSON.
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			status	query		string	false	"Task status"	Enums(pending, failed)	default(failed)
+//	@Success		200		{array}		queue.Record
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		403		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/admin/tasks [get]
+func (c *Controller) GetTasks(ctx *gin.Context) {
+	status := queue.TaskStatus(ctx.DefaultQuery("status", string(queue.TaskFailed)))
+	if status != queue.TaskPending && status != queue.TaskFailed {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending or failed"})
+		return
+	}
+	tasks, err := c.repo.ListTasks(status)
+	if err != nil {
+		log.Printf("error listing %s tasks: %v", status, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get tasks from db"})
+		return
+	}
+	ctx.JSON(http.StatusOK, tasks)
+}
+
+// DeleteTask godoc
+//
+//	@Summary		Delete a durable task
+//	@Description	Delete a persisted task, typically a failed one once it was looked into. Deleting a pending task only keeps it from being resumed after a restart.
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Param			id	path	string	true	"Task ID"
+//	@Success		204
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		403	{object}	map[string]string{}
+//	@Failure		500	{object}	map[string]string{}
+//	@Router			/api/v1/admin/tasks/{id} [delete]
+func (c *Controller) DeleteTask(ctx *gin.Context) {
+	if err := c.repo.DeleteTask(ctx.Param("id")); err != nil {
+		log.Printf("error deleting task %s: %v", ctx.Param("id"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete task from db"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// worker looks up the worker named in the path, answering 404 when missing.
+func (c *Controller) worker(ctx *gin.Context) (*queue.Worker, bool) {
+	worker, found := c.workers.Get(ctx.Param("worker"))
+	if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "worker not found"})
+	}
+	return worker, found
+}
@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/repo"
+	"chat-app/pkg/utils"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	minPasswordLength = 8
+	sessionKey        = "session"
+)
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+	errUnauthenticated = errors.New("authentication required")
+)
+
+// Register godoc
+//
+//	@Summary		Register a user
+//	@Description	Create a user account and log it in
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		models.Credentials	true	"Username and password"
+//	@Success		201		{object}	models.AuthToken
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		409		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/auth/register [post]
+func (c *Controller) Register(ctx *gin.Context) {
+	var creds models.Credentials
+	if err := ctx.ShouldBindJSON(&creds); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "username and password are required"})
+		return
+	}
+	if !usernamePattern.MatchString(creds.Username) || strings.EqualFold(creds.Username, "BOT") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "username must be up to 32 letters, digits, '.', '_' or '-'"})
+		return
+	}
+	if len(creds.Password) < minPasswordLength {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "password must have at least 8 characters"})
+		return
+	}
+
+	hash, err := utils.HashPassword(creds.Password)
+	if err != nil {
+		log.Printf("error hashing password for %s: %v", creds.Username, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register user"})
+		return
+	}
+	user, err := c.repo.AddUser(models.User{
+		Username:     creds.Username,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if errors.Is(err, repo.ErrUsernameTaken) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("error adding user %s to the database: %v", creds.Username, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register user"})
+		return
+	}
+
+	c.issueToken(ctx, http.StatusCreated, user, false)
+}
+
+// Login godoc
+//
+//	@Summary		Log in
+//	@Description	Exchange a username and password for a token authenticating the user
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		models.Credentials	true	"Username and password"
+//	@Success		200		{object}	models.AuthToken
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/auth/login [post]
+func (c *Controller) Login(ctx *gin.Context) {
+	var creds models.Credentials
+	if err := ctx.ShouldBindJSON(&creds); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "username and password are required"})
+		return
+	}
+
+	user, found, err := c.repo.GetUser(creds.Username)
+	if err != nil {
+		log.Printf("error getting user %s: %v", creds.Username, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log in"})
+		return
+	}
+	if !found || !utils.CheckPassword(user.PasswordHash, creds.Password) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
+		return
+	}
+
+	c.issueToken(ctx, http.StatusOK, user, false)
+}
+
+// Refresh godoc
+//
+//	@Summary		Refresh tokens
+//	@Description	Exchange a refresh token for a new access and refresh token pair; the refresh token can only be used once
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		models.RefreshRequest	true	"Refresh token"
+//	@Success		200		{object}	models.AuthToken
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/auth/refresh [post]
+func (c *Controller) Refresh(ctx *gin.Context) {
+	var req models.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	refreshHash := utils.HashToken(req.RefreshToken)
+	session, found, err := c.repo.GetSessionByRefresh(refreshHash)
+	if err != nil {
+		log.Printf("error getting session: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+	if !found || time.Now().After(session.RefreshExpiresAt) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+	user := models.User{Username: session.Nickname}
+	if !session.Guest {
+		user, found, err = c.repo.GetUserByID(session.UserID)
+		if err != nil || !found {
+			log.Printf("error getting user %s of session %s: %v", session.UserID, session.ID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+			return
+		}
+	}
+
+	next, auth, err := c.newSession(user, session.Guest)
+	if err != nil {
+		log.Printf("error issuing token for %s: %v", user.Username, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+	rotated, err := c.repo.RotateSession(refreshHash, next)
+	if err != nil {
+		log.Printf("error rotating session %s: %v", session.ID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+	if !rotated {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+	ctx.JSON(http.StatusOK, auth)
+}
+
+// Logout godoc
+//
+//	@Summary		Log out
+//	@Description	Revoke the session of the access token
+//	@Tags			auth
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Success		204
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		500	{object}	map[string]string{}
+//	@Router			/api/v1/auth/logout [post]
+func (c *Controller) Logout(ctx *gin.Context) {
+	session := sessionFrom(ctx)
+	if err := c.repo.DeleteSession(session.TokenHash); err != nil {
+		log.Printf("error deleting session %s: %v", session.ID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *Controller) issueToken(ctx *gin.Context, status int, user models.User, guest bool) {
+	session, auth, err := c.newSession(user, guest)
+	if err == nil {
+		err = c.repo.AddSession(session)
+	}
+	if err != nil {
+		log.Printf("error issuing token for %s: %v", user.Username, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	ctx.JSON(status, auth)
+}
+
+// newSession generates a token pair for user, returning the session to store
+// and the tokens to hand to the client. Guests have no stored user.
+func (c *Controller) newSession(user models.User, guest bool) (models.Session, models.AuthToken, error) {
+	token, err := utils.NewToken()
+	if err != nil {
+		return models.Session{}, models.AuthToken{}, err
+	}
+	refresh, err := utils.NewToken()
+	if err != nil {
+		return models.Session{}, models.AuthToken{}, err
+	}
+
+	now := time.Now().UTC()
+	session := models.Session{
+		TokenHash:        utils.HashToken(token),
+		RefreshHash:      utils.HashToken(refresh),
+		UserID:           user.ID,
+		Nickname:         user.Username,
+		Guest:            guest,
+		ExpiresAt:        now.Add(c.accessTTL),
+		RefreshExpiresAt: now.Add(c.refreshTTL),
+		CreatedAt:        now,
+	}
+	auth := models.AuthToken{
+		Token:        token,
+		ExpiresAt:    session.ExpiresAt,
+		RefreshToken: refresh,
+		User:         user,
+		Guest:        guest,
+	}
+	return session, auth, nil
+}
+
+// authenticate resolves the unexpired session of the access token passed in
+// the request.
+func (c *Controller) authenticate(r *http.Request) (models.Session, error) {
+	token := utils.RequestToken(r)
+	if token == "" {
+		return models.Session{}, errUnauthenticated
+	}
+	session, found, err := c.repo.GetSession(utils.HashToken(token))
+	if err != nil {
+		return models.Session{}, err
+	}
+	if !found || time.Now().After(session.ExpiresAt) {
+		return models.Session{}, errUnauthenticated
+	}
+	return session, nil
+}
+
+// RequireAuth is the middleware rejecting requests without a valid access
+// token; handlers behind it get the session through sessionFrom.
+func (c *Controller) RequireAuth(ctx *gin.Context) {
+	session, err := c.authenticate(ctx.Request)
+	if errors.Is(err, errUnauthenticated) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("error authenticating request: %v", err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate"})
+		return
+	}
+	ctx.Set(sessionKey, session)
+	ctx.Next()
+}
+
+func sessionFrom(ctx *gin.Context) models.Session {
+	return ctx.MustGet(sessionKey).(models.Session)
+}
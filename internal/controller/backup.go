@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WithBackups lets the admin API take backups of the database with
+// backuper, writing them into dir.
+func WithBackups(backuper repo.Backuper, dir string) Option {
+	return func(c *Controller) error {
+		if dir == "" {
+			return errors.New("backup directory is required")
+		}
+		c.backups = backuper
+		c.backupDir = dir
+		return nil
+	}
+}
+
+// Backup godoc
+//
+//	@Summary		Back up the database
+//	@Description	Write a consistent snapshot of the database into the backup directory of the server, without stopping it. Restore it with the restore subcommand once the server is stopped.
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		201	{object}	models.Backup
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		403	{object}	map[string]string{}
+//	@Failure		500	{object}	map[string]string{}
+//	@Failure		501	{object}	map[string]string{}
+//	@Router			/api/v1/admin/backup [post]
+func (c *Controller) Backup(ctx *gin.Context) {
+	if c.backups == nil {
+		ctx.JSON(http.StatusNotImplemented, gin.H{"error": "backups are not enabled"})
+		return
+	}
+	if err := os.MkdirAll(c.backupDir, 0o750); err != nil {
+		log.Printf("error creating backup directory %s: %v", c.backupDir, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create backup directory"})
+		return
+	}
+
+	now := time.Now().UTC()
+	path := filepath.Join(c.backupDir, repo.BackupFileName(now))
+	err := c.backups.Backup(path)
+	if errors.Is(err, repo.ErrBackupUnsupported) {
+		ctx.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(path)
+	}
+	if err != nil {
+		log.Printf("error backing up the database to %s: %v", path, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to back up the database"})
+		return
+	}
+	log.Printf("backed up the database to %s", path)
+	ctx.JSON(http.StatusCreated, models.Backup{Path: path, Size: info.Size(), CreatedAt: now})
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"chat-app/pkg/bot"
+	"chat-app/pkg/queue"
+	"chat-app/pkg/utils"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const botTaskKind = "bot_command"
+
+// botTask answers a bot command posted to a room. It runs on the bot worker
+// rather than the room one, so a slow command does not hold the room back,
+// and is durable when the controller has a task store. The reply is stored
+// with the task ID as its idempotency key, so a task run again after a crash
+// posts it only once.
+type botTask struct {
+	c         *Controller
+	id        string
+	room      string
+	command   string
+	execCount int
+	mu        sync.Mutex
+}
+
+type botPayload struct {
+	Room    string `json:"room"`
+	Command string `json:"command"`
+}
+
+func (c *Controller) newBotTask(room, command string) *botTask {
+	return &botTask{c: c, id: utils.NewID(), room: room, command: command}
+}
+
+func (c *Controller) decodeBotTask(record queue.Record) (queue.DurableTask, error) {
+	var payload botPayload
+	if err := json.Unmarshal(record.Payload, &payload); err != nil {
+		return nil, err
+	}
+	return &botTask{
+		c:         c,
+		id:        record.ID,
+		room:      payload.Room,
+		command:   payload.Command,
+		execCount: record.Attempts,
+	}, nil
+}
+
+func (t *botTask) TaskID() string {
+	return t.id
+}
+
+func (t *botTask) Kind() string {
+	return botTaskKind
+}
+
+func (t *botTask) Payload() ([]byte, error) {
+	return json.Marshal(botPayload{Room: t.room, Command: t.command})
+}
+
+func (t *botTask) Log() {
+	log.Printf("bot command %q in %s room failed after %d attempts", t.command, t.room, t.ExecCount())
+}
+
+func (t *botTask) Action(ctx context.Context) error {
+	reply, err := bot.ProcessCMD(t.command)
+	if err != nil {
+		return errors.Wrap(err, "bot cmd process err")
+	}
+	reply.ID = utils.NewID()
+	reply.Room = t.room
+	reply.ClientKey = t.id
+
+	message, created, err := t.c.repo.AddMessage(reply)
+	if err != nil {
+		return errors.Wrap(err, "error adding bot message to the database")
+	}
+	if !created {
+		return nil
+	}
+	if room, found := t.c.GetRoom(t.room); found {
+		t.c.broadcast(room, NewMsgTask(message, room.Clients()))
+	}
+	return nil
+}
+
+func (t *botTask) ExecCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.execCount
+}
+
+func (t *botTask) AddExecCount() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.execCount++
+}
@@ -5,10 +5,12 @@ import (
 	"errors"
 	"net/http"
 	"sync"
+	"time"
 
 	_ "chat-app/docs"
 	"chat-app/internal/models"
 	"chat-app/internal/repo"
+	"chat-app/pkg/queue"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -19,7 +21,45 @@ type Controller struct {
 	ctx    context.Context
 	Cancel func()
 	router *gin.Engine
-	repo   *repo.Repo
+	repo   repo.Store
+
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	pingPeriod time.Duration
+	pongWait   time.Duration
+
+	sendBuffer int
+	overflow   models.OverflowPolicy
+
+	// maxMessageSize bounds the content of a message, in bytes.
+	maxMessageSize int
+
+	// deadLetters records the room tasks that failed for good.
+	deadLetters *queue.DeadLetterQueue
+	// taskStore, when set, makes the bot commands survive a restart.
+	taskStore queue.Store
+	botWorker *queue.Worker
+	// workers tracks the bot and room workers for the admin API.
+	workers *queue.Pool
+
+	// admins are the usernames allowed to use the admin API.
+	admins map[string]bool
+
+	roomIdleTimeout time.Duration
+
+	// editWindow is how long after posting a message its author may edit it.
+	editWindow time.Duration
+
+	// retention is the global retention policy, applied every
+	// retentionInterval by the janitor.
+	retention         models.RetentionPolicy
+	retentionInterval time.Duration
+	janitor           janitor
+
+	// backups, when set, takes the backups of the admin API into backupDir.
+	backups   repo.Backuper
+	backupDir string
 
 	mu    sync.Mutex
 	Rooms map[string]*models.Room
@@ -34,19 +74,124 @@ func WithRouter(router *gin.Engine) Option {
 	}
 }
 
-func WithRepo(r *repo.Repo) Option {
+// WithRepo sets the storage of rooms, messages, users and sessions.
+func WithRepo(r repo.Store) Option {
 	return func(c *Controller) error {
 		c.repo = r
 		return nil
 	}
 }
 
+// WithTokenTTL sets how long access and refresh tokens stay valid.
+func WithTokenTTL(access, refresh time.Duration) Option {
+	return func(c *Controller) error {
+		if access <= 0 || refresh <= 0 {
+			return errors.New("token ttl must be positive")
+		}
+		c.accessTTL = access
+		c.refreshTTL = refresh
+		return nil
+	}
+}
+
+// WithKeepalive sets how often bound sockets are pinged and how long they can
+// go without answering before they are considered dead and dropped.
+func WithKeepalive(pingPeriod, pongWait time.Duration) Option {
+	return func(c *Controller) error {
+		if pingPeriod <= 0 || pongWait <= pingPeriod {
+			return errors.New("pong wait must be longer than a positive ping period")
+		}
+		c.pingPeriod = pingPeriod
+		c.pongWait = pongWait
+		return nil
+	}
+}
+
+// WithSendBuffer sets how many outbound frames each socket buffers and what
+// happens to a client that falls further behind.
+func WithSendBuffer(size int, overflow models.OverflowPolicy) Option {
+	return func(c *Controller) error {
+		if size <= 0 {
+			return errors.New("send buffer size must be positive")
+		}
+		if overflow != models.OverflowDropOldest && overflow != models.OverflowDisconnect {
+			return errors.New("unknown send buffer overflow policy")
+		}
+		c.sendBuffer = size
+		c.overflow = overflow
+		return nil
+	}
+}
+
+// WithMaxMessageSize sets how many bytes the content of a message may take;
+// inbound frames are bounded accordingly.
+func WithMaxMessageSize(size int) Option {
+	return func(c *Controller) error {
+		if size <= 0 {
+			return errors.New("max message size must be positive")
+		}
+		c.maxMessageSize = size
+		return nil
+	}
+}
+
+// WithTaskStore persists the queued bot commands in store, resuming the
+// pending ones at startup.
+func WithTaskStore(store queue.Store) Option {
+	return func(c *Controller) error {
+		c.taskStore = store
+		return nil
+	}
+}
+
+// WithAdmins allows the registered users with the given usernames to use the
+// admin API.
+func WithAdmins(usernames ...string) Option {
+	return func(c *Controller) error {
+		for _, username := range usernames {
+			if username != "" {
+				c.admins[username] = true
+			}
+		}
+		return nil
+	}
+}
+
+// WithRoomIdleTimeout sets how long a room stays in memory, with its worker
+// running, after its last socket closed.
+func WithRoomIdleTimeout(timeout time.Duration) Option {
+	return func(c *Controller) error {
+		if timeout <= 0 {
+			return errors.New("room idle timeout must be positive")
+		}
+		c.roomIdleTimeout = timeout
+		return nil
+	}
+}
+
 func NewController(opts ...Option) (*Controller, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := &Controller{
-		Rooms:  make(map[string]*models.Room),
-		ctx:    ctx,
-		Cancel: cancel,
+		Rooms:      make(map[string]*models.Room),
+		ctx:        ctx,
+		Cancel:     cancel,
+		accessTTL:  15 * time.Minute,
+		refreshTTL: 30 * 24 * time.Hour,
+		pingPeriod: 54 * time.Second,
+		pongWait:   60 * time.Second,
+		sendBuffer: 256,
+		overflow:   models.OverflowDisconnect,
+
+		maxMessageSize: 4096,
+
+		deadLetters: queue.NewDeadLetterQueue(100),
+		workers:     queue.NewPool(),
+		admins:      map[string]bool{},
+
+		roomIdleTimeout:   10 * time.Minute,
+		editWindow:        15 * time.Minute,
+		retentionInterval: 10 * time.Minute,
+		janitor:           janitor{rooms: map[string]*models.RoomPruneStats{}},
 	}
 	for _, opt := range opts {
 		if err := opt(c); err != nil {
@@ -61,14 +206,26 @@ func NewController(opts ...Option) (*Controller, error) {
 		return nil, errors.New("repo cannot be nil")
 	}
 
+	workerOpts := []queue.WorkerOption{queue.WithDeadLetters(c.deadLetters)}
+	if c.taskStore != nil {
+		workerOpts = append(workerOpts, queue.WithStore(c.taskStore, map[string]queue.Decoder{
+			botTaskKind: c.decodeBotTask,
+		}))
+	}
+	c.botWorker = queue.NewWorker("bot", workerOpts...)
+	c.workers.Add(c.botWorker)
+	go c.botWorker.StartWorker(c.ctx)
+	go c.reapRooms()
+	go c.runJanitor()
+
 	return c, nil
 }
 
 func (c *Controller) RegisterRoutes() {
 	c.router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -84,9 +241,38 @@ func (c *Controller) RegisterRoutes() {
 	api := c.router.Group("/api/v1")
 	{
 		api.GET("/health", c.Health)
-		api.GET("/rooms", c.GetRooms)
-		api.GET("/rooms/:room/bind", c.BindRoom)
-		api.GET("/rooms/:room/:nickname/send", c.SendMessage)
+		api.POST("/auth/register", c.Register)
+		api.POST("/auth/login", c.Login)
+		api.POST("/auth/refresh", c.Refresh)
+		api.POST("/auth/guest", c.Guest)
+	}
+	authed := api.Group("", c.RequireAuth)
+	{
+		authed.POST("/auth/logout", c.Logout)
+		authed.GET("/rooms", c.GetRooms)
+		authed.GET("/rooms/:room/bind", c.BindRoom)
+		authed.GET("/rooms/:room/messages", c.GetMessages)
+		authed.PATCH("/rooms/:room/messages/:id", c.EditMessage)
+		authed.GET("/rooms/:room/messages/:id/revisions", c.GetRevisions)
+		authed.GET("/rooms/:room/members", c.GetMembers)
+		authed.GET("/rooms/:room/export", c.ExportRoom)
+		authed.GET("/rooms/:room/:nickname/send", c.SendMessage)
+		authed.GET("/search", c.Search)
+	}
+	admin := authed.Group("/admin", c.RequireAdmin)
+	{
+		admin.GET("/workers", c.GetWorkers)
+		admin.POST("/workers/:worker/pause", c.PauseWorker)
+		admin.POST("/workers/:worker/resume", c.ResumeWorker)
+		admin.POST("/workers/:worker/drain", c.DrainWorker)
+		admin.GET("/dead-letters", c.GetDeadLetters)
+		admin.GET("/tasks", c.GetTasks)
+		admin.DELETE("/tasks/:id", c.DeleteTask)
+		admin.GET("/retention", c.GetRetention)
+		admin.POST("/retention/prune", c.PruneMessages)
+		admin.PUT("/rooms/:room/retention", c.SetRoomRetention)
+		admin.POST("/rooms/:room/import", c.ImportRoom)
+		admin.POST("/backup", c.Backup)
 	}
 }
 
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+var (
+	errMessageNotFound  = errors.New("message not found")
+	errNotAuthor        = errors.New("only the author of a message can edit it")
+	errEditWindowClosed = errors.New("the message can no longer be edited")
+)
+
+// WithEditWindow sets how long after posting a message its author may edit
+// it.
+func WithEditWindow(window time.Duration) Option {
+	return func(c *Controller) error {
+		if window <= 0 {
+			return errors.New("edit window must be positive")
+		}
+		c.editWindow = window
+		return nil
+	}
+}
+
+// editMessage replaces the content of the message id that owner posted in
+// roomID, and broadcasts the edit to the room when it is loaded. An edit to
+// the same content returns the message untouched. Authorship is checked
+// against the owner, not the nickname, which a guest may have been suffixed
+// to or another guest may take once it is free.
+func (c *Controller) editMessage(roomID, owner, id, content string) (models.Message, error) {
+	if err := c.checkContent(content); err != nil {
+		return models.Message{}, err
+	}
+	msg, found, err := c.repo.GetMessage(roomID, id)
+	if err != nil {
+		return models.Message{}, errors.Wrap(err, "error getting message from the database")
+	}
+	if !found {
+		return models.Message{}, errMessageNotFound
+	}
+	if msg.Owner == "" || msg.Owner != owner {
+		return models.Message{}, errNotAuthor
+	}
+	now := time.Now().UTC()
+	if now.Sub(msg.Timestamp) > c.editWindow {
+		return models.Message{}, errEditWindowClosed
+	}
+	if msg.Content == content {
+		return msg, nil
+	}
+
+	edited, found, err := c.repo.EditMessage(roomID, id, content, now)
+	if err != nil {
+		return models.Message{}, errors.Wrap(err, "error editing message in the database")
+	}
+	if !found {
+		return models.Message{}, errMessageNotFound
+	}
+	if room, found := c.GetRoom(roomID); found {
+		c.broadcast(room, NewFrameTask(models.NewEditedFrame(edited), room.Clients()))
+	}
+	log.Printf("Message %s edited in %s room: %s", edited.ID, roomID, edited.Content)
+	return edited, nil
+}
+
+// editStatus maps the errors of editMessage to HTTP statuses.
+func editStatus(err error) int {
+	switch {
+	case errors.Is(err, errContentTooLong):
+		return http.StatusBadRequest
+	case errors.Is(err, errMessageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotAuthor), errors.Is(err, errEditWindowClosed):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// EditMessage godoc
+//
+//	@Summary		Edit a message
+//	@Description	Replace the content of one of the caller's messages, within the edit window after it was posted (15 minutes by default). The previous content is kept as a revision, and the bound sockets of the room get an "edited" frame with the message.
+//	@Tags			room
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			room	path		string				true	"Room name"
+//	@Param			id		path		string				true	"Message ID"
+//	@Param			edit	body		models.EditRequest	true	"New content"
+//	@Success		200		{object}	models.Message
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		403		{object}	map[string]string{}
+//	@Failure		404		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/rooms/{room}/messages/{id} [patch]
+func (c *Controller) EditMessage(ctx *gin.Context) {
+	var req models.EditRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session := sessionFrom(ctx)
+	msg, err := c.editMessage(ctx.Param("room"), session.Owner(), ctx.Param("id"), req.Content)
+	if err != nil {
+		status := editStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Printf("error editing msg %s in %s room: %v", ctx.Param("id"), ctx.Param("room"), err)
+			ctx.JSON(status, gin.H{"error": "failed to edit msg"})
+			return
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, msg)
+}
+
+// GetRevisions godoc
+//
+//	@Summary		Get the edit history of a message
+//	@Description	List the contents a message had before each of its edits, oldest first, each with the time it was written
+//	@Tags			room
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			room	path		string	true	"Room name"
+//	@Param			id		path		string	true	"Message ID"
+//	@Success		200		{array}		models.MessageRevision
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		404		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/rooms/{room}/messages/{id}/revisions [get]
+func (c *Controller) GetRevisions(ctx *gin.Context) {
+	_, found, err := c.repo.GetMessage(ctx.Param("room"), ctx.Param("id"))
+	if err == nil && !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": errMessageNotFound.Error()})
+		return
+	}
+	var revisions []models.MessageRevision
+	if err == nil {
+		revisions, err = c.repo.ListRevisions(ctx.Param("id"))
+	}
+	if err != nil {
+		log.Printf("error listing revisions of msg %s: %v", ctx.Param("id"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get msg revisions from db"})
+		return
+	}
+	ctx.JSON(http.StatusOK, revisions)
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"chat-app/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxNicknameSuffix = 100
+
+var errNicknameTaken = errors.New("nickname already in use in this room")
+
+// Guest godoc
+//
+//	@Summary		Join as a guest
+//	@Description	Get a token for an anonymous guest using the given nickname. The nickname is only reserved in a room while the guest is bound to it, and cannot be the username of a registered user.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		models.GuestRequest	true	"Guest nickname"
+//	@Success		201		{object}	models.AuthToken
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		409		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/auth/guest [post]
+func (c *Controller) Guest(ctx *gin.Context) {
+	var req models.GuestRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "nickname is required"})
+		return
+	}
+	if !usernamePattern.MatchString(req.Nickname) || strings.EqualFold(req.Nickname, "BOT") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "nickname must be up to 32 letters, digits, '.', '_' or '-'"})
+		return
+	}
+
+	registered, err := c.nicknameRegistered(req.Nickname)
+	if err != nil {
+		log.Printf("error getting user %s: %v", req.Nickname, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	if registered {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "nickname belongs to a registered user"})
+		return
+	}
+
+	c.issueToken(ctx, http.StatusCreated, models.User{Username: req.Nickname}, true)
+}
+
+func (c *Controller) nicknameRegistered(nickname string) (bool, error) {
+	_, found, err := c.repo.GetUser(nickname)
+	return found, err
+}
+
+// reserveNickname reserves the session nickname in room. Registered users
+// only ever get their username, which a guest bound before it was registered
+// may still hold, giving errNicknameTaken; a guest whose nickname is taken
+// gets the first free "nickname-N" when suffix is set, and errNicknameTaken
+// otherwise.
+func (c *Controller) reserveNickname(room *models.Room, session models.Session, suffix bool) (string, error) {
+	if !session.Guest {
+		if !room.Reserve(session.Nickname, session.Owner()) {
+			return "", errNicknameTaken
+		}
+		return session.Nickname, nil
+	}
+
+	for i := 1; i <= maxNicknameSuffix; i++ {
+		nickname := session.Nickname
+		if i > 1 {
+			nickname = fmt.Sprintf("%s-%d", session.Nickname, i)
+		}
+		registered, err := c.nicknameRegistered(nickname)
+		if err != nil {
+			return "", err
+		}
+		if !registered && room.Reserve(nickname, session.Owner()) {
+			return nickname, nil
+		}
+		if !suffix {
+			break
+		}
+	}
+	return "", errNicknameTaken
+}
@@ -3,16 +3,16 @@ package controller
 import (
 	"context"
 	"log"
-	"math/rand"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
-	"time"
 
 	"chat-app/internal/models"
+	"chat-app/internal/repo"
 	"chat-app/pkg/queue"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 )
 
@@ -32,36 +32,140 @@ func (c *Controller) Health(ctx *gin.Context) {
 // GetRooms godoc
 //
 //	@Summary		Get chat rooms
-//	@Description	List available chat rooms
+//	@Description	List the stored and active chat rooms, most recently active first, with the number of users connected to each, their message count and the time of their latest message
 //	@Tags			room
+//	@Security		BearerAuth
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{array}	string
+//	@Success		200	{array}		models.UIRoom
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		500	{object}	map[string]string{}
 //	@Router			/api/v1/rooms [get]
 func (c *Controller) GetRooms(ctx *gin.Context) {
-	r := []string{}
-	for key := range c.Rooms {
-		r = append(r, key)
+	r, err := c.repo.GetRoomStats()
+	if err != nil {
+		log.Printf("error getting rooms: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get rooms from db"})
+		return
 	}
+
+	byRoom := map[string]int{}
+	for i, room := range r {
+		byRoom[room.Room] = i
+	}
+	for _, room := range c.ListRooms() {
+		i, found := byRoom[room.ID]
+		if !found {
+			i = len(r)
+			r = append(r, models.UIRoom{Room: room.ID})
+		}
+		r[i].Users = len(room.Members())
+	}
+
+	sort.SliceStable(r, func(i, j int) bool {
+		a, b := r[i].LastActivity, r[j].LastActivity
+		if a == nil || b == nil {
+			return a != nil || (b == nil && r[i].Room < r[j].Room)
+		}
+		return a.After(*b)
+	})
 	ctx.JSON(http.StatusOK, r)
 }
 
+// GetMembers godoc
+//
+//	@Summary		Get room members
+//	@Description	List who is connected to a room, since when, and whether they have been idle for more than 5 minutes
+//	@Tags			room
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			room	path		string	true	"Room name"
+//	@Success		200		{array}		models.Member
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		404		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/rooms/{room}/members [get]
+func (c *Controller) GetMembers(ctx *gin.Context) {
+	roomID := ctx.Param("room")
+	if room, found := c.GetRoom(roomID); found {
+		ctx.JSON(http.StatusOK, room.Members())
+		return
+	}
+	// a stored room nobody is bound to is not loaded
+	if _, found, err := c.repo.GetRoom(roomID); err != nil {
+		log.Printf("error getting %s room: %v", roomID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get room from db"})
+		return
+	} else if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	ctx.JSON(http.StatusOK, []models.Member{})
+}
+
+// GetMessages godoc
+//
+//	@Summary		Get room messages
+//	@Description	Page through a room history with message ID cursors. Without cursors the latest messages are returned; "before" loads older scrollback and "after" fetches what was posted since a given message. Messages are always in ascending ID order.
+//	@Tags			room
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			room	path		string	true	"Room name"
+//	@Param			before	query		string	false	"Only messages older than this message ID"
+//	@Param			after	query		string	false	"Only messages newer than this message ID"
+//	@Param			limit	query		int		false	"Page size (default 50, max 200)"
+//	@Success		200		{object}	models.MessagePage
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/rooms/{room}/messages [get]
+func (c *Controller) GetMessages(ctx *gin.Context) {
+	query := repo.MessageQuery{
+		Before: ctx.Query("before"),
+		After:  ctx.Query("after"),
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		query.Limit = n
+	}
+
+	page, err := c.repo.ListMessages(ctx.Param("room"), query)
+	if err != nil {
+		log.Printf("error listing %s room msgs: %v", ctx.Param("room"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get room msgs from db"})
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
 type messageTask struct {
-	message   models.Message
-	connPool  []*websocket.Conn
+	frame     models.Frame
+	connPool  []*models.Client
 	execCount int
 	mu        sync.Mutex
 }
 
-func NewMsgTask(msg models.Message, conn []*websocket.Conn) queue.Task {
+func NewMsgTask(msg models.Message, conn []*models.Client) queue.Task {
+	return NewFrameTask(models.NewMessageFrame(msg), conn)
+}
+
+// NewFrameTask broadcasts any server frame, such as presence events, through
+// the room worker like a message.
+func NewFrameTask(frame models.Frame, conn []*models.Client) queue.Task {
 	return &messageTask{
-		message:  msg,
+		frame:    frame,
 		connPool: conn,
 	}
 }
 
 func (t *messageTask) Log() {
-	log.Printf("message from %s reached execution limit", t.message.Nickname)
+	log.Printf("%s frame %s failed after %d attempts", t.frame.Type, t.frame.ID, t.ExecCount())
 }
 
 func (t *messageTask) Action(ctx context.Context) error {
@@ -69,17 +173,14 @@ func (t *messageTask) Action(ctx context.Context) error {
 	if t.connPool == nil {
 		return nil
 	}
-	if t.ExecCount() >= queue.ExecutionLimit {
-		return errors.New("message reached execution limit")
-	}
-
-	defer t.AddExecCount()
 
+	// Send only queues the frame, so a slow client cannot hold the others back;
+	// one that overflows its buffer or broke its socket is closed, which ends
+	// its read loop and removes it from the room.
 	for i, conn := range t.connPool {
-		err := conn.WriteMessage(websocket.TextMessage, []byte(t.message.Fmt()))
-		if err != nil {
-			log.Printf("error sending message [%d] from %s: %v", i, t.message.Nickname, err)
-			time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
+		err := conn.Send(t.frame)
+		if err != nil && !errors.Is(err, models.ErrClientClosed) {
+			log.Printf("error sending %s frame [%d] to %s: %v", t.frame.Type, i, conn.Nickname, err)
 		}
 	}
 
@@ -1,17 +1,24 @@
 package controller
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"chat-app/internal/models"
 	"chat-app/internal/repo"
 	"chat-app/pkg/queue"
+	"chat-app/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -24,13 +31,19 @@ var (
 		Worker: queue.NewWorker("testroom"),
 	}
 	testNickname = "testuser"
+	testPeer     = "peer"
+	testPassword = "password1"
 )
 
 type HandlersTestSuite struct {
 	suite.Suite
 	router *gin.Engine
 	server *httptest.Server
-	repo   *repo.Repo
+	ctrl   *Controller
+	repo   repo.Store
+	tokens map[string]string
+	// newStore opens the storage the suite runs against.
+	newStore func() (repo.Store, error)
 }
 
 func (suite *HandlersTestSuite) SetupSuite() {
@@ -38,22 +51,85 @@ func (suite *HandlersTestSuite) SetupSuite() {
 	suite.router = gin.Default()
 
 	var err error
-	suite.repo, err = repo.NewRepo(":memory:")
+	suite.repo, err = suite.newStore()
 	if err != nil {
 		suite.T().Fatal(err)
 	}
 
-	ctrl, err := NewController(
+	opts := []Option{
 		WithRouter(suite.router),
 		WithRepo(suite.repo),
-	)
-	suite.NoError(err)
-	ctrl.RegisterRoutes()
+		WithTaskStore(suite.repo),
+		WithAdmins(testNickname),
+	}
+	if backuper, ok := suite.repo.(repo.Backuper); ok {
+		opts = append(opts, WithBackups(backuper, suite.T().TempDir()))
+	}
+	suite.ctrl, err = NewController(opts...)
+	suite.Require().NoError(err)
+	suite.ctrl.RegisterRoutes()
 	suite.server = httptest.NewServer(suite.router)
+
+	suite.tokens = map[string]string{}
+	for _, username := range []string{testNickname, testPeer} {
+		code, auth := suite.postAuth("register", username, testPassword)
+		suite.Require().Equal(http.StatusCreated, code)
+		suite.tokens[username] = auth.Token
+	}
+}
+
+func (suite *HandlersTestSuite) postAuth(action, username, password string) (int, models.AuthToken) {
+	return suite.post("/api/v1/auth/"+action, "", models.Credentials{Username: username, Password: password})
+}
+
+func (suite *HandlersTestSuite) post(path, token string, payload any) (int, models.AuthToken) {
+	body, err := json.Marshal(payload)
+	suite.Require().NoError(err)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", path, bytes.NewReader(body))
+	suite.Require().NoError(err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	suite.router.ServeHTTP(rec, req)
+
+	auth := models.AuthToken{}
+	if rec.Code < 300 && rec.Body.Len() > 0 {
+		suite.NoError(json.Unmarshal(rec.Body.Bytes(), &auth))
+	}
+	return rec.Code, auth
+}
+
+// bindURL returns the websocket URL binding username to room.
+func (suite *HandlersTestSuite) bindURL(room, username string) string {
+	return serverBindURL(suite.server, room, suite.tokens[username])
+}
+
+func serverBindURL(server *httptest.Server, room, token string) string {
+	return fmt.Sprintf("ws%s/api/v1/rooms/%s/bind?token=%s", strings.TrimPrefix(server.URL, "http"), room, token)
+}
+
+// newTestController starts a controller of its own against the suite repo,
+// served by a test server; both are stopped when the test ends. It returns
+// the controller and the bind URL of a suite user to a room of that server.
+func (suite *HandlersTestSuite) newTestController(opts ...Option) (*Controller, func(room, username string) string) {
+	router := gin.New()
+	ctrl, err := NewController(append([]Option{WithRouter(router), WithRepo(suite.repo)}, opts...)...)
+	suite.Require().NoError(err)
+	ctrl.RegisterRoutes()
+	server := httptest.NewServer(router)
+	suite.T().Cleanup(func() {
+		server.Close()
+		ctrl.Cancel()
+	})
+	return ctrl, func(room, username string) string {
+		return serverBindURL(server, room, suite.tokens[username])
+	}
 }
 
 func (suite *HandlersTestSuite) TearDownSuite() {
 	suite.server.Close()
+	suite.ctrl.Cancel()
 }
 
 func (suite *HandlersTestSuite) Test1Health() {
@@ -67,39 +143,1104 @@ func (suite *HandlersTestSuite) Test1Health() {
 	suite.JSONEq(`{"status": "up"}`, w.Body.String())
 }
 
+func (suite *HandlersTestSuite) Test1Auth() {
+	code, _ := suite.postAuth("register", testNickname, testPassword)
+	suite.Equal(http.StatusConflict, code)
+	code, _ = suite.postAuth("register", "bad name", testPassword)
+	suite.Equal(http.StatusBadRequest, code)
+	code, _ = suite.postAuth("register", "shorty", "short")
+	suite.Equal(http.StatusBadRequest, code)
+
+	code, auth := suite.postAuth("login", testNickname, testPassword)
+	suite.Equal(http.StatusOK, code)
+	suite.NotEmpty(auth.Token)
+	suite.Equal(testNickname, auth.User.Username)
+	code, _ = suite.postAuth("login", testNickname, "wrong password")
+	suite.Equal(http.StatusUnauthorized, code)
+	code, _ = suite.postAuth("login", "nobody", testPassword)
+	suite.Equal(http.StatusUnauthorized, code)
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/api/v1/rooms/%s/%s/send?content=hi&token=%s", testRoom.ID, testNickname, suite.tokens[testPeer]), nil)
+	suite.NoError(err)
+	suite.router.ServeHTTP(rec, req)
+	suite.Equal(http.StatusForbidden, rec.Code, "cannot post as another user")
+
+	_, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/api/v1/rooms/%s/bind?token=forged", strings.TrimPrefix(suite.server.URL, "http"), testRoom.ID), nil)
+	suite.Error(err)
+	suite.Equal(http.StatusUnauthorized, resp.StatusCode)
+}
+
+func (suite *HandlersTestSuite) Test1Sessions() {
+	_, auth := suite.postAuth("login", testNickname, testPassword)
+	getRooms := func(token string) int {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/rooms", nil)
+		suite.NoError(err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		suite.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	suite.Equal(http.StatusUnauthorized, getRooms(""))
+	suite.Equal(http.StatusOK, getRooms(auth.Token))
+
+	code, refreshed := suite.post("/api/v1/auth/refresh", "", models.RefreshRequest{RefreshToken: auth.RefreshToken})
+	suite.Equal(http.StatusOK, code)
+	suite.NotEqual(auth.Token, refreshed.Token)
+	suite.NotEqual(auth.RefreshToken, refreshed.RefreshToken)
+	suite.Equal(http.StatusUnauthorized, getRooms(auth.Token), "rotation revokes the previous access token")
+	suite.Equal(http.StatusOK, getRooms(refreshed.Token))
+
+	code, _ = suite.post("/api/v1/auth/refresh", "", models.RefreshRequest{RefreshToken: auth.RefreshToken})
+	suite.Equal(http.StatusUnauthorized, code, "refresh tokens are single use")
+
+	expired, err := suite.repo.RotateSession(utils.HashToken(refreshed.RefreshToken), models.Session{
+		TokenHash:        utils.HashToken(refreshed.Token),
+		RefreshHash:      utils.HashToken(refreshed.RefreshToken),
+		ExpiresAt:        time.Now().Add(-time.Second),
+		RefreshExpiresAt: time.Now().Add(time.Hour),
+	})
+	suite.NoError(err)
+	suite.True(expired)
+	suite.Equal(http.StatusUnauthorized, getRooms(refreshed.Token), "expired access token")
+	code, refreshed = suite.post("/api/v1/auth/refresh", "", models.RefreshRequest{RefreshToken: refreshed.RefreshToken})
+	suite.Equal(http.StatusOK, code)
+
+	code, _ = suite.post("/api/v1/auth/logout", refreshed.Token, nil)
+	suite.Equal(http.StatusNoContent, code)
+	suite.Equal(http.StatusUnauthorized, getRooms(refreshed.Token))
+
+	dialer := websocket.Dialer{Subprotocols: []string{models.SubprotocolJSON, utils.TokenSubprotocolPrefix + suite.tokens[testNickname]}}
+	ws, _, err := dialer.Dial(fmt.Sprintf("ws%s/api/v1/rooms/%s/bind", strings.TrimPrefix(suite.server.URL, "http"), "subprotocol"), nil)
+	suite.Require().NoError(err)
+	defer ws.Close()
+	suite.Equal(models.SubprotocolJSON, ws.Subprotocol())
+	suite.readFrame(ws, models.FrameChatLoaded)
+}
+
+func (suite *HandlersTestSuite) Test1Guests() {
+	code, _ := suite.post("/api/v1/auth/guest", "", models.GuestRequest{Nickname: testNickname})
+	suite.Equal(http.StatusConflict, code, "registered usernames are not available to guests")
+
+	guestTokens := []string{}
+	guestURL := func(query string) string {
+		code, auth := suite.post("/api/v1/auth/guest", "", models.GuestRequest{Nickname: "bob"})
+		suite.Require().Equal(http.StatusCreated, code)
+		suite.True(auth.Guest)
+		guestTokens = append(guestTokens, auth.Token)
+		return fmt.Sprintf("ws%s/api/v1/rooms/guests/bind?token=%s%s", strings.TrimPrefix(suite.server.URL, "http"), auth.Token, query)
+	}
+	bind := func(query string) (*websocket.Conn, string) {
+		ws, _, err := websocket.DefaultDialer.Dial(guestURL(query), nil)
+		suite.Require().NoError(err)
+		return ws, suite.readFrame(ws, models.FrameWelcome).Nickname
+	}
+
+	ws1, nickname := bind("")
+	suite.Equal("bob", nickname)
+	ws2, nickname := bind("")
+	defer ws2.Close()
+	suite.Equal("bob-2", nickname)
+
+	_, resp, err := websocket.DefaultDialer.Dial(guestURL("&on_conflict=reject"), nil)
+	suite.Error(err)
+	suite.Equal(http.StatusConflict, resp.StatusCode)
+
+	suite.NoError(ws2.WriteJSON(models.Frame{Type: models.FrameMessage, Content: "hi from a guest"}))
+	msg := suite.readFrame(ws1, models.FrameMessage)
+	suite.Equal("bob-2", msg.Message.Nickname)
+
+	send := func(token, nickname string) int {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", fmt.Sprintf("/api/v1/rooms/guests/%s/send?content=hi&token=%s", nickname, token), nil)
+		suite.NoError(err)
+		suite.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	suite.Equal(http.StatusConflict, send(guestTokens[1], "bob"), "the suffixed guest cannot post as the guest holding the nickname")
+	suite.Equal(http.StatusForbidden, send(guestTokens[0], "bob-2"), "nor the other way around")
+	suite.Equal(http.StatusOK, send(guestTokens[1], "bob-2"))
+	suite.Equal("bob-2", suite.readFrame(ws1, models.FrameMessage).Message.Nickname)
+
+	suite.NoError(ws1.Close())
+	suite.Eventually(func() bool {
+		ws, resp, err := websocket.DefaultDialer.Dial(guestURL("&on_conflict=reject"), nil)
+		if err != nil {
+			suite.Equal(http.StatusConflict, resp.StatusCode)
+			return false
+		}
+		defer ws.Close()
+		return suite.readFrame(ws, models.FrameWelcome).Nickname == "bob"
+	}, 3*time.Second, 50*time.Millisecond, "closing the socket releases the nickname")
+
+	code, auth := suite.post("/api/v1/auth/guest", "", models.GuestRequest{Nickname: "carol"})
+	suite.Require().Equal(http.StatusCreated, code)
+	guest, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s/api/v1/rooms/guests/bind?token=%s", strings.TrimPrefix(suite.server.URL, "http"), auth.Token), nil)
+	suite.Require().NoError(err)
+	suite.Equal("carol", suite.readFrame(guest, models.FrameWelcome).Nickname)
+	code, auth = suite.postAuth("register", "carol", testPassword)
+	suite.Require().Equal(http.StatusCreated, code)
+	suite.tokens["carol"] = auth.Token
+	_, resp, err = websocket.DefaultDialer.Dial(suite.bindURL("guests", "carol"), nil)
+	suite.Error(err)
+	suite.Equal(http.StatusConflict, resp.StatusCode, "the guest keeps the nickname it was bound with")
+
+	suite.NoError(guest.Close())
+	suite.Eventually(func() bool {
+		ws, _, err := websocket.DefaultDialer.Dial(suite.bindURL("guests", "carol"), nil)
+		if err != nil {
+			return false
+		}
+		defer ws.Close()
+		return suite.readFrame(ws, models.FrameWelcome).Nickname == "carol"
+	}, 3*time.Second, 50*time.Millisecond, "the user gets the nickname once the guest leaves")
+
+	code, auth = suite.post("/api/v1/auth/guest", "", models.GuestRequest{Nickname: "erin"})
+	suite.Require().Equal(http.StatusCreated, code)
+	code, _ = suite.postAuth("register", "erin", testPassword)
+	suite.Require().Equal(http.StatusCreated, code)
+	suite.Equal(http.StatusConflict, send(auth.Token, "erin"), "a guest cannot post as a nickname registered after its token was issued")
+}
+
 func (suite *HandlersTestSuite) Test2BindRoom() {
 	fmt.Println("starting Test2BindRoom")
-	bindingUrl := fmt.Sprintf("ws%s/api/v1/rooms/%s/bind?nickname=%s", strings.TrimPrefix(suite.server.URL, "http"), testRoom.ID, testNickname)
-	ws1, _, err := websocket.DefaultDialer.Dial(bindingUrl, nil)
+	bindingUrl := suite.bindURL(testRoom.ID, testNickname)
+	dialer := websocket.Dialer{Subprotocols: []string{models.SubprotocolText}}
+	ws1, _, err := dialer.Dial(bindingUrl, nil)
 	suite.NoError(err)
 	fmt.Println("dial no err")
 	defer ws1.Close()
+	suite.Equal(models.SubprotocolText, ws1.Subprotocol())
+	suite.readUntil(ws1, "^chat loaded$")
 
-	readingURL := fmt.Sprintf("ws%s/api/v1/rooms/%s/%s/send?content=hello", strings.TrimPrefix(suite.server.URL, "http"), testRoom.ID, testNickname)
+	readingURL := fmt.Sprintf("ws%s/api/v1/rooms/%s/%s/send?content=hello&token=%s", strings.TrimPrefix(suite.server.URL, "http"), testRoom.ID, testNickname, suite.tokens[testNickname])
 	_, _, _ = websocket.DefaultDialer.Dial(readingURL, nil)
 
-	_, msg, err := ws1.ReadMessage()
+	pattern := fmt.Sprintf(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\] %s: %s$`, testNickname, "hello")
+	suite.readUntil(ws1, pattern)
+}
+
+func (suite *HandlersTestSuite) Test2BindRoomFrames() {
+	bindingUrl := suite.bindURL(testRoom.ID, testNickname)
+	ws1, _, err := websocket.DefaultDialer.Dial(bindingUrl, nil)
 	suite.NoError(err)
+	defer ws1.Close()
+	history := suite.readFrame(ws1, models.FrameMessage)
+	suite.Equal(testRoom.ID, history.Message.Room)
+	suite.Equal("hello", history.Message.Content)
+	suite.readFrame(ws1, models.FrameChatLoaded)
 
-	pattern := fmt.Sprintf(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\] %s: %s$`, testNickname, "hello")
-	suite.Regexp(pattern, string(msg))
+	ws2, _, err := websocket.DefaultDialer.Dial(suite.bindURL(testRoom.ID, testPeer), nil)
+	suite.NoError(err)
+	defer ws2.Close()
+	suite.readFrame(ws2, models.FrameChatLoaded)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameTyping}))
+	typing := suite.readFrame(ws2, models.FrameTyping)
+	suite.Equal(testNickname, typing.Nickname)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "r1", Content: "over the socket"}))
+	ack := suite.readFrame(ws1, models.FrameAck)
+	suite.Equal("r1", ack.Ref)
+	suite.Empty(ack.Error)
+	msg := suite.readFrame(ws2, models.FrameMessage)
+	suite.NotEmpty(msg.ID)
+	suite.Equal(testNickname, msg.Message.Nickname)
+	suite.Equal("over the socket", msg.Message.Content)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "long", Content: strings.Repeat("x", 4097)}))
+	ack = suite.readFrame(ws1, models.FrameAck)
+	suite.Equal("long", ack.Ref)
+	suite.Equal("content is too long", ack.Error)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/api/v1/rooms/%s/%s/send?content=%s&token=%s", testRoom.ID, testNickname, strings.Repeat("x", 4097), suite.tokens[testNickname]), nil)
+	suite.NoError(err)
+	suite.router.ServeHTTP(rec, req)
+	suite.Equal(http.StatusBadRequest, rec.Code)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: "bogus", Ref: "r2"}))
+	ack = suite.readFrame(ws1, models.FrameAck)
+	suite.Equal("r2", ack.Ref)
+	suite.Equal(`unknown frame type "bogus"`, ack.Error)
+}
+
+func (suite *HandlersTestSuite) Test2BindRoomEdit() {
+	room := "edits"
+	ws1, _, err := websocket.DefaultDialer.Dial(suite.bindURL(room, testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws1.Close()
+	suite.readFrame(ws1, models.FrameChatLoaded)
+	ws2, _, err := websocket.DefaultDialer.Dial(suite.bindURL(room, testPeer), nil)
+	suite.Require().NoError(err)
+	defer ws2.Close()
+	suite.readFrame(ws2, models.FrameChatLoaded)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "post", Content: "helo"}))
+	posted := suite.readFrame(ws1, models.FrameAck).Message
+	suite.Require().NotNil(posted)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameEdit, Ref: "e1", MessageID: posted.ID, Content: "hello"}))
+	ack := suite.readFrame(ws1, models.FrameAck)
+	suite.Equal("e1", ack.Ref)
+	suite.Empty(ack.Error)
+	suite.Require().NotNil(ack.Message)
+	suite.Equal("hello", ack.Message.Content)
+	edited := suite.readFrame(ws2, models.FrameEdited)
+	suite.Equal(posted.ID, edited.Message.ID)
+	suite.Equal("hello", edited.Message.Content)
+	suite.NotNil(edited.Message.EditedAt)
+
+	suite.NoError(ws2.WriteJSON(models.Frame{Type: models.FrameEdit, Ref: "e2", MessageID: posted.ID, Content: "hijacked"}))
+	suite.Equal("only the author of a message can edit it", suite.readFrame(ws2, models.FrameAck).Error)
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameEdit, Ref: "e3", MessageID: "unknown", Content: "hello"}))
+	suite.Equal("message not found", suite.readFrame(ws1, models.FrameAck).Error)
+
+	request := func(method, path, username, body string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[username])
+		suite.router.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.Bytes()
+	}
+	path := "/api/v1/rooms/edits/messages/" + posted.ID
+	code, _ := request("PATCH", path, testPeer, `{"content":"hijacked"}`)
+	suite.Equal(http.StatusForbidden, code)
+	code, _ = request("PATCH", path, testNickname, `{}`)
+	suite.Equal(http.StatusBadRequest, code)
+	code, _ = request("PATCH", path, testNickname, `{"content":"`+strings.Repeat("x", 4097)+`"}`)
+	suite.Equal(http.StatusBadRequest, code)
+	code, _ = request("PATCH", "/api/v1/rooms/elsewhere/messages/"+posted.ID, testNickname, `{"content":"moved"}`)
+	suite.Equal(http.StatusNotFound, code)
+	code, body := request("PATCH", path, testNickname, `{"content":"hello world"}`)
+	suite.Require().Equal(http.StatusOK, code)
+	msg := models.Message{}
+	suite.NoError(json.Unmarshal(body, &msg))
+	suite.Equal("hello world", msg.Content)
+	suite.Equal("hello world", suite.readFrame(ws2, models.FrameEdited).Message.Content)
+
+	code, body = request("GET", path+"/revisions", testPeer, "")
+	suite.Require().Equal(http.StatusOK, code)
+	revisions := []models.MessageRevision{}
+	suite.NoError(json.Unmarshal(body, &revisions))
+	suite.Require().Len(revisions, 2)
+	suite.Equal("helo", revisions[0].Content)
+	suite.Equal("hello", revisions[1].Content)
+	code, _ = request("GET", "/api/v1/rooms/edits/messages/unknown/revisions", testPeer, "")
+	suite.Equal(http.StatusNotFound, code)
+
+	guests := []string{}
+	for range 2 {
+		code, auth := suite.post("/api/v1/auth/guest", "", models.GuestRequest{Nickname: "dave"})
+		suite.Require().Equal(http.StatusCreated, code)
+		guests = append(guests, auth.Token)
+	}
+	ws3, _, err := websocket.DefaultDialer.Dial(serverBindURL(suite.server, room, guests[0]), nil)
+	suite.Require().NoError(err)
+	defer ws3.Close()
+	suite.readFrame(ws3, models.FrameChatLoaded)
+	ws4, _, err := websocket.DefaultDialer.Dial(serverBindURL(suite.server, room, guests[1]), nil)
+	suite.Require().NoError(err)
+	defer ws4.Close()
+	suite.Equal("dave-2", suite.readFrame(ws4, models.FrameWelcome).Nickname)
+	suite.readFrame(ws4, models.FrameChatLoaded)
+	suite.NoError(ws4.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "guest", Content: "from dave-2"}))
+	suite.Require().NoError(ws4.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	var guestMsg *models.Message
+	for guestMsg == nil {
+		var frame models.Frame
+		suite.Require().NoError(ws4.ReadJSON(&frame))
+		if frame.Type == models.FrameAck && frame.Ref == "guest" {
+			guestMsg = frame.Message
+		}
+	}
+	suite.Require().NotNil(guestMsg)
+	suite.tokens["dave"], suite.tokens["dave-2"] = guests[0], guests[1]
+	code, _ = request("PATCH", "/api/v1/rooms/edits/messages/"+guestMsg.ID, "dave", `{"content":"hijacked"}`)
+	suite.Equal(http.StatusForbidden, code, "guests are told apart by their session, not their nickname")
+	code, _ = request("PATCH", "/api/v1/rooms/edits/messages/"+guestMsg.ID, "dave-2", `{"content":"edited by dave-2"}`)
+	suite.Equal(http.StatusOK, code, "a suffixed guest edits its own messages")
+
+	user, _, err := suite.repo.GetUser(testNickname)
+	suite.Require().NoError(err)
+	old, _, err := suite.repo.AddMessage(models.Message{Room: room, Nickname: testNickname, Owner: user.ID, Content: "too late", Timestamp: time.Now().UTC().Add(-time.Hour)})
+	suite.Require().NoError(err)
+	code, body = request("PATCH", "/api/v1/rooms/edits/messages/"+old.ID, testNickname, `{"content":"fixed"}`)
+	suite.Equal(http.StatusForbidden, code)
+	suite.Contains(string(body), "no longer be edited")
+}
+
+func (suite *HandlersTestSuite) Test2BindRoomIdempotentSend() {
+	bindingUrl := suite.bindURL("idempotent", testNickname)
+	ws1, _, err := websocket.DefaultDialer.Dial(bindingUrl, nil)
+	suite.NoError(err)
+	defer ws1.Close()
+	suite.readFrame(ws1, models.FrameChatLoaded)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "a", Key: "k1", Content: "retried"}))
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "b", Key: "k1", Content: "retried"}))
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "c", Content: "after"}))
+
+	acks := map[string]string{}
+	broadcasts := []string{}
+	suite.Require().NoError(ws1.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	for len(acks) < 3 || len(broadcasts) == 0 || broadcasts[len(broadcasts)-1] != "after" {
+		var frame models.Frame
+		suite.Require().NoError(ws1.ReadJSON(&frame))
+		switch frame.Type {
+		case models.FrameAck:
+			acks[frame.Ref] = frame.Message.ID
+		case models.FrameMessage:
+			broadcasts = append(broadcasts, frame.Message.Content)
+		}
+	}
+	suite.NotEmpty(acks["a"])
+	suite.Equal(acks["a"], acks["b"])
+	suite.NotEqual(acks["a"], acks["c"])
+	suite.Equal([]string{"retried", "after"}, broadcasts)
+}
+
+func (suite *HandlersTestSuite) Test2BindRoomResume() {
+	bindingUrl := suite.bindURL("resume", testNickname)
+	ws1, _, err := websocket.DefaultDialer.Dial(bindingUrl, nil)
+	suite.NoError(err)
+	defer ws1.Close()
+	suite.readFrame(ws1, models.FrameChatLoaded)
+
+	sent := []models.Message{}
+	for _, content := range []string{"m1", "m2", "m3"} {
+		suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: content, Content: content}))
+		sent = append(sent, *suite.readFrame(ws1, models.FrameAck).Message)
+	}
+
+	replayed := func(since string) []string {
+		ws, _, err := websocket.DefaultDialer.Dial(bindingUrl+"&since="+url.QueryEscape(since), nil)
+		suite.Require().NoError(err)
+		defer ws.Close()
+		contents := []string{}
+		suite.Require().NoError(ws.SetReadDeadline(time.Now().Add(3 * time.Second)))
+		for {
+			var frame models.Frame
+			suite.Require().NoError(ws.ReadJSON(&frame))
+			switch frame.Type {
+			case models.FrameMessage:
+				contents = append(contents, frame.Message.Content)
+			case models.FrameSyncComplete:
+				return contents
+			}
+		}
+	}
+
+	suite.Equal([]string{"m2", "m3"}, replayed(sent[0].ID))
+	suite.Equal([]string{}, replayed(sent[2].ID))
+	suite.Equal([]string{"m3"}, replayed(sent[1].Timestamp.Format(time.RFC3339Nano)))
+}
+
+func (suite *HandlersTestSuite) Test2BotCommand() {
+	ws1, _, err := websocket.DefaultDialer.Dial(suite.bindURL("bot", testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws1.Close()
+	suite.readFrame(ws1, models.FrameChatLoaded)
+
+	suite.NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Content: "/help"}))
+	var reply models.Frame
+	for reply.Message == nil || reply.Message.Nickname != "BOT" {
+		reply = suite.readFrame(ws1, models.FrameMessage)
+	}
+	suite.Contains(reply.Message.Content, "available commands")
+
+	page, err := suite.repo.ListMessages("bot", repo.MessageQuery{})
+	suite.NoError(err)
+	suite.Require().Len(page.Messages, 2, "bot replies are stored")
+	suite.Equal(reply.Message.ID, page.Messages[1].ID)
+	suite.Eventually(func() bool {
+		pending, err := suite.repo.PendingTasks("bot")
+		return err == nil && len(pending) == 0
+	}, time.Second, 10*time.Millisecond, "answered commands leave the task store")
+}
+
+func (suite *HandlersTestSuite) Test2BotCommandResumed() {
+	payload, err := json.Marshal(botPayload{Room: "resumed-bot", Command: "/help"})
+	suite.Require().NoError(err)
+	record := queue.Record{ID: utils.NewID(), Worker: "bot", Kind: botTaskKind, Payload: payload, Status: queue.TaskPending, CreatedAt: time.Now().UTC()}
+	suite.Require().NoError(suite.repo.SaveTask(record))
+	// a previous run already posted the reply before crashing
+	_, _, err = suite.repo.AddMessage(models.Message{Room: "resumed-bot", Nickname: "BOT", Content: "first run", ClientKey: record.ID})
+	suite.Require().NoError(err)
+
+	suite.newTestController(WithTaskStore(suite.repo))
+	suite.Eventually(func() bool {
+		pending, err := suite.repo.PendingTasks("bot")
+		return err == nil && len(pending) == 0
+	}, time.Second, 10*time.Millisecond, "pending commands are resumed at startup")
+
+	page, err := suite.repo.ListMessages("resumed-bot", repo.MessageQuery{})
+	suite.NoError(err)
+	suite.Require().Len(page.Messages, 1, "a resumed command posts its reply only once")
+	suite.Equal("first run", page.Messages[0].Content)
+}
+
+// readUntil reads from ws until a text frame matches pattern, failing after a
+// few seconds without a match.
+func (suite *HandlersTestSuite) readUntil(ws *websocket.Conn, pattern string) string {
+	re := regexp.MustCompile(pattern)
+	suite.Require().NoError(ws.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	defer ws.SetReadDeadline(time.Time{})
+	for {
+		_, msg, err := ws.ReadMessage()
+		suite.Require().NoError(err, "waiting for %s", pattern)
+		msg = bytes.TrimSpace(msg)
+		if re.Match(msg) {
+			return string(msg)
+		}
+	}
+}
+
+// readFrame reads from ws until a JSON frame of the given type arrives.
+func (suite *HandlersTestSuite) readFrame(ws *websocket.Conn, frameType models.FrameType) models.Frame {
+	suite.Require().NoError(ws.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	defer ws.SetReadDeadline(time.Time{})
+	for {
+		var frame models.Frame
+		suite.Require().NoError(ws.ReadJSON(&frame), "waiting for %s frame", frameType)
+		if frame.Type == frameType {
+			return frame
+		}
+	}
 }
 
 func (suite *HandlersTestSuite) Test3GetRooms() {
 	rec := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "/api/v1/rooms", nil)
 	suite.NoError(err)
+	req.Header.Set("Authorization", "Bearer "+suite.tokens[testNickname])
 	suite.router.ServeHTTP(rec, req)
 	suite.Equal(200, rec.Code)
 
 	reqBody, err := io.ReadAll(rec.Result().Body)
 	suite.NoError(err)
-	reqRoom := []string{}
+	reqRoom := []models.UIRoom{}
 	err = json.Unmarshal(reqBody, &reqRoom)
 	suite.NoError(err)
-	suite.Equal(testRoom.ID, reqRoom[0])
+	byName := map[string]models.UIRoom{}
+	for _, room := range reqRoom {
+		byName[room.Room] = room
+	}
+	suite.Require().Contains(byName, testRoom.ID)
+	suite.GreaterOrEqual(byName[testRoom.ID].Messages, int64(2))
+	suite.NotNil(byName[testRoom.ID].LastActivity)
+	suite.Require().Contains(byName, "subprotocol")
+	suite.Zero(byName["subprotocol"].Messages)
+	suite.Nil(byName["subprotocol"].LastActivity)
+	suite.Equal("subprotocol", reqRoom[len(reqRoom)-1].Room, "rooms without messages come last")
+}
+
+func (suite *HandlersTestSuite) Test3Presence() {
+	getMembers := func(room string) (int, []models.Member) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", fmt.Sprintf("/api/v1/rooms/%s/members", room), nil)
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[testNickname])
+		suite.router.ServeHTTP(rec, req)
+		members := []models.Member{}
+		if rec.Code == http.StatusOK {
+			suite.NoError(json.Unmarshal(rec.Body.Bytes(), &members))
+		}
+		return rec.Code, members
+	}
+	code, _ := getMembers("nowhere")
+	suite.Equal(http.StatusNotFound, code)
+
+	ws1, _, err := websocket.DefaultDialer.Dial(suite.bindURL("presence", testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws1.Close()
+	suite.readFrame(ws1, models.FrameChatLoaded)
+
+	ws2, _, err := websocket.DefaultDialer.Dial(suite.bindURL("presence", testPeer), nil)
+	suite.Require().NoError(err)
+	suite.readFrame(ws2, models.FrameChatLoaded)
+	suite.Equal(testPeer, suite.readFrame(ws1, models.FrameJoin).Nickname)
+
+	ws3, _, err := websocket.DefaultDialer.Dial(suite.bindURL("presence", testPeer), nil)
+	suite.Require().NoError(err)
+	suite.readFrame(ws3, models.FrameChatLoaded)
+
+	code, members := getMembers("presence")
+	suite.Equal(http.StatusOK, code)
+	suite.Require().Len(members, 2)
+	suite.Equal(testPeer, members[0].Nickname)
+	suite.Equal(2, members[0].Connections)
+	suite.Equal(testNickname, members[1].Nickname)
+	suite.False(members[1].Idle)
+
+	suite.NoError(ws2.Close())
+	suite.NoError(ws3.Close())
+	leave := suite.readFrame(ws1, models.FrameLeave)
+	suite.Equal(testPeer, leave.Nickname, "leave is announced once the last connection closes")
+	_, members = getMembers("presence")
+	suite.Len(members, 1)
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/v1/rooms", nil)
+	suite.NoError(err)
+	req.Header.Set("Authorization", "Bearer "+suite.tokens[testNickname])
+	suite.router.ServeHTTP(rec, req)
+	rooms := []models.UIRoom{}
+	suite.NoError(json.Unmarshal(rec.Body.Bytes(), &rooms))
+	suite.Contains(rooms, models.UIRoom{Room: "presence", Users: 1})
+}
+
+func (suite *HandlersTestSuite) Test4GetMessages() {
+	get := func(query string) (int, models.MessagePage) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", fmt.Sprintf("/api/v1/rooms/%s/messages%s", testRoom.ID, query), nil)
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[testNickname])
+		suite.router.ServeHTTP(rec, req)
+		page := models.MessagePage{}
+		if rec.Code == http.StatusOK {
+			suite.NoError(json.Unmarshal(rec.Body.Bytes(), &page))
+		}
+		return rec.Code, page
+	}
+
+	code, all := get("")
+	suite.Equal(http.StatusOK, code)
+	suite.Require().GreaterOrEqual(len(all.Messages), 2)
+	last := all.Messages[len(all.Messages)-1]
+
+	code, page := get("?limit=1&before=" + last.ID)
+	suite.Equal(http.StatusOK, code)
+	suite.Len(page.Messages, 1)
+	suite.Equal(len(all.Messages) > 2, page.HasMore)
+	suite.Equal(all.Messages[len(all.Messages)-2].ID, page.Messages[0].ID)
+
+	code, page = get("?after=" + all.Messages[len(all.Messages)-2].ID)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(models.Messages{last}, page.Messages)
+	suite.False(page.HasMore)
+
+	code, _ = get("?limit=zero")
+	suite.Equal(http.StatusBadRequest, code)
+}
+
+func (suite *HandlersTestSuite) Test4Search() {
+	get := func(query url.Values) (int, models.SearchPage) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/search?"+query.Encode(), nil)
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[testPeer])
+		suite.router.ServeHTTP(rec, req)
+		page := models.SearchPage{}
+		if rec.Code == http.StatusOK {
+			suite.NoError(json.Unmarshal(rec.Body.Bytes(), &page))
+		}
+		return rec.Code, page
+	}
+
+	start := time.Now().UTC()
+	for i, content := range []string{"searchable <i>first</i>", "searchable second", "not this one"} {
+		_, _, err := suite.repo.AddMessage(models.Message{
+			Room:      "search",
+			Nickname:  testNickname,
+			Content:   content,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		})
+		suite.Require().NoError(err)
+	}
+
+	code, page := get(url.Values{"q": {"searchable"}, "room": {"search"}, "author": {testNickname}, "limit": {"1"}})
+	suite.Equal(http.StatusOK, code)
+	suite.Require().Len(page.Results, 1)
+	suite.True(page.HasMore)
+	suite.Equal("<mark>searchable</mark> second", page.Results[0].Snippet)
+
+	code, page = get(url.Values{"q": {"searchable"}, "room": {"search"}, "before": {page.Results[0].ID}})
+	suite.Equal(http.StatusOK, code)
+	suite.Require().Len(page.Results, 1)
+	suite.False(page.HasMore)
+	suite.Equal("<mark>searchable</mark> &lt;i&gt;first&lt;/i&gt;", page.Results[0].Snippet)
+
+	code, page = get(url.Values{"q": {"searchable"}, "to": {start.Add(time.Second).Format(time.RFC3339Nano)}})
+	suite.Equal(http.StatusOK, code)
+	suite.Len(page.Results, 1)
+	code, page = get(url.Values{"q": {"searchable"}, "author": {testPeer}})
+	suite.Equal(http.StatusOK, code)
+	suite.Empty(page.Results)
+
+	code, _ = get(url.Values{})
+	suite.Equal(http.StatusBadRequest, code)
+	code, _ = get(url.Values{"q": {"searchable"}, "from": {"yesterday"}})
+	suite.Equal(http.StatusBadRequest, code)
+}
+
+func (suite *HandlersTestSuite) Test4Retention() {
+	request := func(method, path, username, body string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[username])
+		suite.router.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.Bytes()
+	}
+	now := time.Now().UTC()
+	for _, room := range []string{"retained", "held"} {
+		suite.Require().NoError(suite.repo.AddRoom(room))
+		for i := 3; i > 0; i-- {
+			_, _, err := suite.repo.AddMessage(models.Message{Room: room, Nickname: testNickname, Content: "old", Timestamp: now.Add(-time.Duration(i) * time.Hour)})
+			suite.Require().NoError(err)
+		}
+	}
+
+	code, _ := request("PUT", "/api/v1/admin/rooms/retained/retention", testPeer, `{"retention":{"max_count":1}}`)
+	suite.Equal(http.StatusForbidden, code)
+	code, _ = request("PUT", "/api/v1/admin/rooms/unknown/retention", testNickname, `{"retention":{"max_count":1}}`)
+	suite.Equal(http.StatusNotFound, code)
+	code, _ = request("PUT", "/api/v1/admin/rooms/retained/retention", testNickname, `{"retention":{"max_age":"soon"}}`)
+	suite.Equal(http.StatusBadRequest, code)
+	code, _ = request("PUT", "/api/v1/admin/rooms/retained/retention", testNickname, `{"retention":{"max_count":-1}}`)
+	suite.Equal(http.StatusBadRequest, code)
+
+	code, body := request("PUT", "/api/v1/admin/rooms/retained/retention", testNickname, `{"retention":{"max_age":"90m","max_count":5}}`)
+	suite.Require().Equal(http.StatusOK, code)
+	retention := models.RoomRetention{}
+	suite.NoError(json.Unmarshal(body, &retention))
+	suite.Equal(models.RetentionPolicy{MaxAge: 90 * time.Minute, MaxCount: 5}, retention.Retention)
+	code, _ = request("PUT", "/api/v1/admin/rooms/held/retention", testNickname, `{"retention":{"max_count":1},"legal_hold":true}`)
+	suite.Require().Equal(http.StatusOK, code)
+
+	code, body = request("POST", "/api/v1/admin/retention/prune", testNickname, "")
+	suite.Require().Equal(http.StatusOK, code)
+	stats := models.RetentionStats{}
+	suite.NoError(json.Unmarshal(body, &stats))
+	suite.GreaterOrEqual(stats.Runs, int64(1))
+	suite.Equal(int64(2), stats.LastPruned)
+	rooms := map[string]models.RoomPruneStats{}
+	for _, room := range stats.Rooms {
+		rooms[room.Room] = room
+	}
+	suite.Equal(int64(2), rooms["retained"].Pruned)
+	suite.NotNil(rooms["retained"].LastPrunedAt)
+	suite.Zero(rooms["held"].Pruned)
+	suite.True(rooms["held"].LegalHold)
+
+	for room, left := range map[string]int{"retained": 1, "held": 3} {
+		page, err := suite.repo.ListMessages(room, repo.MessageQuery{})
+		suite.NoError(err)
+		suite.Len(page.Messages, left, room)
+	}
+
+	code, body = request("GET", "/api/v1/admin/retention", testNickname, "")
+	suite.Require().Equal(http.StatusOK, code)
+	suite.NoError(json.Unmarshal(body, &stats))
+	suite.Equal("10m0s", stats.Interval)
+	suite.True(stats.Policy.IsZero(), "messages are kept forever by default")
+}
+
+func (suite *HandlersTestSuite) Test4ExportImport() {
+	request := func(method, path, username, body string) (*httptest.ResponseRecorder, []byte) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[username])
+		suite.router.ServeHTTP(rec, req)
+		return rec, rec.Body.Bytes()
+	}
+	room := "handover"
+	suite.Require().NoError(suite.repo.AddRoom(room))
+	start := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	for i, content := range []string{"first", "<i>second</i>"} {
+		_, _, err := suite.repo.AddMessage(models.Message{Room: room, Nickname: testPeer, Content: content, Timestamp: start.Add(time.Duration(i) * time.Minute)})
+		suite.Require().NoError(err)
+	}
+
+	rec, _ := request("GET", "/api/v1/rooms/unknown/export", testPeer, "")
+	suite.Equal(http.StatusNotFound, rec.Code)
+	rec, _ = request("GET", "/api/v1/rooms/handover/export?format=pdf", testPeer, "")
+	suite.Equal(http.StatusBadRequest, rec.Code)
+
+	rec, body := request("GET", "/api/v1/rooms/handover/export?format=txt", testPeer, "")
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	suite.Equal("text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	suite.Equal(`attachment; filename=handover.txt`, rec.Header().Get("Content-Disposition"))
+	suite.Equal("[2024-03-01 09:30:00] peer: first\n[2024-03-01 09:31:00] peer: <i>second</i>\n", string(body))
+
+	rec, body = request("GET", "/api/v1/rooms/handover/export?format=html", testPeer, "")
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	suite.Contains(string(body), "&lt;i&gt;second&lt;/i&gt;")
+
+	rec, export := request("GET", "/api/v1/rooms/handover/export", testPeer, "")
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	suite.Equal("application/x-ndjson", rec.Header().Get("Content-Type"))
+
+	rec, _ = request("POST", "/api/v1/admin/rooms/imported/import", testPeer, string(export))
+	suite.Equal(http.StatusForbidden, rec.Code)
+	rec, body = request("POST", "/api/v1/admin/rooms/imported/import", testNickname, string(export))
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	result := models.ImportResult{}
+	suite.NoError(json.Unmarshal(body, &result))
+	suite.Equal(models.ImportResult{Room: "imported", Imported: 2}, result)
+	rec, body = request("POST", "/api/v1/admin/rooms/imported/import", testNickname, string(export)+"{}\n")
+	suite.Equal(http.StatusBadRequest, rec.Code)
+	suite.Contains(string(body), "line 3")
+
+	page, err := suite.repo.ListMessages("imported", repo.MessageQuery{})
+	suite.NoError(err)
+	suite.Require().Len(page.Messages, 2, "messages imported before are skipped")
+	suite.Equal(testPeer, page.Messages[1].Nickname)
+	suite.Equal("<i>second</i>", page.Messages[1].Content)
+	suite.True(start.Add(time.Minute).Equal(page.Messages[1].Timestamp))
+}
+
+func (suite *HandlersTestSuite) Test4Backup() {
+	request := func(username string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/admin/backup", nil)
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[username])
+		suite.router.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.Bytes()
+	}
+	code, _ := request(testPeer)
+	suite.Equal(http.StatusForbidden, code)
+
+	code, body := request(testNickname)
+	if _, ok := suite.repo.(repo.Backuper); !ok {
+		suite.Equal(http.StatusNotImplemented, code)
+		return
+	}
+	suite.Require().Equal(http.StatusCreated, code)
+	backup := models.Backup{}
+	suite.Require().NoError(json.Unmarshal(body, &backup))
+	suite.Positive(backup.Size)
+
+	snapshot, err := repo.NewRepo(backup.Path)
+	suite.Require().NoError(err)
+	defer snapshot.Close()
+	user, found, err := snapshot.GetUser(testNickname)
+	suite.NoError(err)
+	suite.True(found, "the backup holds the data of the live database")
+	suite.Equal(testNickname, user.Username)
+}
+
+func (suite *HandlersTestSuite) Test4AdminWorkers() {
+	request := func(method, path, username string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, nil)
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[username])
+		suite.router.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.Bytes()
+	}
+	code, _ := request("GET", "/api/v1/admin/workers", testPeer)
+	suite.Equal(http.StatusForbidden, code, "only admins use the admin API")
+
+	code, body := request("GET", "/api/v1/admin/workers", testNickname)
+	suite.Require().Equal(http.StatusOK, code)
+	workers := []queue.WorkerStats{}
+	suite.NoError(json.Unmarshal(body, &workers))
+	names := []string{}
+	for _, worker := range workers {
+		names = append(names, worker.Name)
+	}
+	suite.Contains(names, "bot")
+	suite.Contains(names, models.WorkerName(testRoom.ID))
+
+	worker := "/api/v1/admin/workers/" + models.WorkerName("admin")
+	code, _ = request("POST", worker+"/pause", testNickname)
+	suite.Equal(http.StatusNotFound, code)
+
+	ws, _, err := websocket.DefaultDialer.Dial(suite.bindURL("admin", testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws.Close()
+	suite.readFrame(ws, models.FrameChatLoaded)
+
+	stats := queue.WorkerStats{}
+	code, body = request("POST", worker+"/pause", testNickname)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.NoError(json.Unmarshal(body, &stats))
+	suite.True(stats.Paused)
+
+	suite.NoError(ws.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "1", Content: "held back"}))
+	suite.readFrame(ws, models.FrameAck)
+	code, body = request("GET", "/api/v1/admin/workers", testNickname)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.NoError(json.Unmarshal(body, &workers))
+	for _, w := range workers {
+		if w.Name == models.WorkerName("admin") {
+			suite.Equal(1, w.Depth, "the broadcast waits in the paused queue")
+		}
+	}
+
+	code, body = request("POST", worker+"/drain?timeout=2s", testNickname)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.NoError(json.Unmarshal(body, &stats))
+	suite.False(stats.Paused)
+	suite.Equal(0, stats.Depth)
+	suite.Equal("held back", suite.readFrame(ws, models.FrameMessage).Message.Content)
+
+	code, _ = request("POST", worker+"/drain?timeout=soon", testNickname)
+	suite.Equal(http.StatusBadRequest, code)
+
+	suite.ctrl.deadLetters.Add(queue.DeadLetter{Worker: "bot", Task: &botTask{id: "dead-1"}, Err: errors.New("boom"), Attempts: 3, FailedAt: time.Now().UTC()})
+	code, body = request("GET", "/api/v1/admin/dead-letters", testNickname)
+	suite.Require().Equal(http.StatusOK, code)
+	letters := []queue.DeadLetterInfo{}
+	suite.NoError(json.Unmarshal(body, &letters))
+	suite.Require().NotEmpty(letters)
+	last := letters[len(letters)-1]
+	suite.Equal("dead-1", last.TaskID)
+	suite.Equal(botTaskKind, last.Kind)
+	suite.Equal("boom", last.Error)
+	code, _ = request("GET", "/api/v1/admin/dead-letters", testPeer)
+	suite.Equal(http.StatusForbidden, code)
+
+	failed := queue.Record{ID: utils.NewID(), Worker: "bot", Kind: botTaskKind, Payload: []byte(`{}`), Status: queue.TaskFailed, Attempts: 3, LastError: "boom", CreatedAt: time.Now().UTC()}
+	suite.Require().NoError(suite.repo.SaveTask(failed))
+	listFailed := func() []string {
+		code, body := request("GET", "/api/v1/admin/tasks", testNickname)
+		suite.Require().Equal(http.StatusOK, code)
+		tasks := []queue.Record{}
+		suite.NoError(json.Unmarshal(body, &tasks))
+		ids := []string{}
+		for _, task := range tasks {
+			suite.Equal(queue.TaskFailed, task.Status)
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+	suite.Contains(listFailed(), failed.ID)
+	code, _ = request("GET", "/api/v1/admin/tasks?status=done", testNickname)
+	suite.Equal(http.StatusBadRequest, code)
+	code, _ = request("DELETE", "/api/v1/admin/tasks/"+failed.ID, testPeer)
+	suite.Equal(http.StatusForbidden, code)
+	code, _ = request("DELETE", "/api/v1/admin/tasks/"+failed.ID, testNickname)
+	suite.Equal(http.StatusNoContent, code)
+	suite.NotContains(listFailed(), failed.ID)
+}
+
+func (suite *HandlersTestSuite) Test5Keepalive() {
+	ctrl, bindURL := suite.newTestController(WithKeepalive(50*time.Millisecond, 200*time.Millisecond))
+
+	ws1, _, err := websocket.DefaultDialer.Dial(bindURL("keepalive", testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws1.Close()
+	suite.readFrame(ws1, models.FrameChatLoaded)
+
+	// ws2 never reads again, so it never answers the pings
+	ws2, _, err := websocket.DefaultDialer.Dial(bindURL("keepalive", testPeer), nil)
+	suite.Require().NoError(err)
+	defer ws2.Close()
+	suite.readFrame(ws2, models.FrameChatLoaded)
+	suite.Equal(testPeer, suite.readFrame(ws1, models.FrameJoin).Nickname)
+
+	suite.Equal(testPeer, suite.readFrame(ws1, models.FrameLeave).Nickname)
+	room, found := ctrl.GetRoom("keepalive")
+	suite.Require().True(found)
+	suite.Len(room.Clients(), 1, "the unresponsive socket is removed from the room")
+}
+
+func (suite *HandlersTestSuite) Test5SlowConsumer() {
+	_, bindURL := suite.newTestController(WithSendBuffer(4, models.OverflowDisconnect), WithMaxMessageSize(128*1024))
+
+	ws1, _, err := websocket.DefaultDialer.Dial(bindURL("slow", testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws1.Close()
+	suite.readFrame(ws1, models.FrameChatLoaded)
+
+	// ws2 stops reading, so its socket and then its send buffer fill up
+	ws2, _, err := websocket.DefaultDialer.Dial(bindURL("slow", testPeer), nil)
+	suite.Require().NoError(err)
+	defer ws2.Close()
+	suite.readFrame(ws2, models.FrameChatLoaded)
+	suite.readFrame(ws1, models.FrameJoin)
+
+	content := strings.Repeat("x", 128*1024)
+	for i := 0; i < 200; i++ {
+		ref := fmt.Sprint(i)
+		suite.Require().NoError(ws1.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: ref, Content: content}))
+		suite.Require().NoError(ws1.SetReadDeadline(time.Now().Add(3 * time.Second)))
+		for {
+			var frame models.Frame
+			suite.Require().NoError(ws1.ReadJSON(&frame), "the sender keeps getting its acks")
+			if frame.Type == models.FrameLeave {
+				suite.Equal(testPeer, frame.Nickname)
+				return
+			}
+			if frame.Type == models.FrameAck && frame.Ref == ref {
+				break
+			}
+		}
+	}
+	suite.Fail("the slow consumer was never disconnected")
+}
+
+// newClient returns a client on the server side of a websocket, and the
+// socket of its peer.
+func (suite *HandlersTestSuite) newClient(buffer int, overflow models.OverflowPolicy) (*models.Client, *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := utils.NewSocketConnection(w, r, models.SubprotocolJSON)
+		suite.NoError(err)
+		conns <- conn
+	}))
+	suite.T().Cleanup(server.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { ws.Close() })
+	client := models.NewClient(testNickname, testNickname, <-conns, buffer, overflow)
+	suite.T().Cleanup(func() { client.Close() })
+	return client, ws
+}
+
+func (suite *HandlersTestSuite) Test5SyncOverflow() {
+	frames := func(client *models.Client, n int) error {
+		for i := 0; i < n; i++ {
+			msg := models.Message{ID: fmt.Sprint(i), Content: fmt.Sprint(i)}
+			if err := client.Send(models.NewMessageFrame(msg)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	client, _ := suite.newClient(4, models.OverflowDisconnect)
+	client.StartSync()
+	suite.NoError(frames(client, 4))
+	suite.ErrorIs(frames(client, 1), models.ErrClientOverflow, "the frames held back while syncing are bound")
+
+	client, ws := suite.newClient(4, models.OverflowDropOldest)
+	go client.WritePump()
+	client.StartSync()
+	suite.NoError(frames(client, 6))
+	suite.NoError(client.FinishSync(models.NewFrame(models.FrameChatLoaded)))
+	suite.readFrame(ws, models.FrameChatLoaded)
+	suite.Equal("2", suite.readFrame(ws, models.FrameMessage).Message.Content, "the oldest held back frames are dropped")
+}
+
+func (suite *HandlersTestSuite) Test5SyncEdited() {
+	client, ws := suite.newClient(8, models.OverflowDisconnect)
+	go client.WritePump()
+	msg := models.Message{ID: "1", Content: "before"}
+	client.StartSync()
+	suite.NoError(client.Replay(models.NewMessageFrame(msg)))
+	suite.NoError(client.Send(models.NewMessageFrame(msg)))
+	msg.Content = "after"
+	suite.NoError(client.Send(models.NewEditedFrame(msg)))
+	suite.NoError(client.FinishSync(models.NewFrame(models.FrameChatLoaded)))
+
+	types := []models.FrameType{}
+	suite.Require().NoError(ws.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	for len(types) < 3 {
+		var frame models.Frame
+		suite.Require().NoError(ws.ReadJSON(&frame))
+		types = append(types, frame.Type)
+		if frame.Type == models.FrameEdited {
+			suite.Equal("after", frame.Message.Content)
+		}
+	}
+	suite.Equal([]models.FrameType{models.FrameMessage, models.FrameChatLoaded, models.FrameEdited}, types, "only the replayed message frame is skipped")
+}
+
+func (suite *HandlersTestSuite) Test5RoomReaping() {
+	ctrl, bindURL := suite.newTestController(WithRoomIdleTimeout(100 * time.Millisecond))
+
+	ws, _, err := websocket.DefaultDialer.Dial(bindURL("reaped", testNickname), nil)
+	suite.Require().NoError(err)
+	suite.readFrame(ws, models.FrameChatLoaded)
+	suite.NoError(ws.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "1", Content: "before eviction"}))
+	suite.readFrame(ws, models.FrameAck)
+
+	time.Sleep(300 * time.Millisecond)
+	_, found := ctrl.GetRoom("reaped")
+	suite.True(found, "rooms with a bound socket are kept")
+	suite.NoError(ws.Close())
+
+	suite.Eventually(func() bool {
+		_, found := ctrl.GetRoom("reaped")
+		return !found
+	}, 2*time.Second, 20*time.Millisecond, "idle rooms are evicted")
+	_, found = ctrl.workers.Get(models.WorkerName("reaped"))
+	suite.False(found, "the worker of an evicted room is gone")
+
+	ws, _, err = websocket.DefaultDialer.Dial(bindURL("reaped", testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws.Close()
+	suite.Equal("before eviction", suite.readFrame(ws, models.FrameMessage).Message.Content, "the room is rebuilt from the repo")
+	suite.readFrame(ws, models.FrameChatLoaded)
+	suite.NoError(ws.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "2", Content: "after eviction"}))
+	suite.Equal("after eviction", suite.readFrame(ws, models.FrameMessage).Message.Content)
+}
+
+func (suite *HandlersTestSuite) Test5LazyRooms() {
+	ctrl, bindURL := suite.newTestController()
+	_, found := ctrl.GetRoom(testRoom.ID)
+	suite.False(found, "stored rooms are not loaded at startup")
+	suite.Len(ctrl.workers.Stats(), 1, "only the bot worker runs")
+
+	ws, _, err := websocket.DefaultDialer.Dial(bindURL(testRoom.ID, testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws.Close()
+	suite.Equal(testRoom.ID, suite.readFrame(ws, models.FrameMessage).Message.Room, "the room is loaded on bind")
+	suite.readFrame(ws, models.FrameChatLoaded)
+	room, found := ctrl.GetRoom(testRoom.ID)
+	suite.Require().True(found)
+	suite.True(room.Worker.Stats().Started)
+
+	suite.Require().NoError(suite.repo.AddRoom("unloaded"))
+	request := func(path string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		suite.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+suite.tokens[testNickname])
+		suite.router.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.Bytes()
+	}
+	code, body := request("/api/v1/rooms/unloaded/members")
+	suite.Equal(http.StatusOK, code)
+	suite.JSONEq("[]", string(body), "a stored room nobody is bound to has no members")
+	code, _ = request("/api/v1/rooms/unloaded/" + testNickname + "/send?content=loaded")
+	suite.Equal(http.StatusOK, code, "the send shim loads a stored room")
+	page, err := suite.repo.ListMessages("unloaded", repo.MessageQuery{})
+	suite.NoError(err)
+	suite.Require().Len(page.Messages, 1)
+	suite.Equal("loaded", page.Messages[0].Content)
+}
+
+func (suite *HandlersTestSuite) Test6Shutdown() {
+	ctrl, bindURL := suite.newTestController()
+
+	ws, _, err := websocket.DefaultDialer.Dial(bindURL("shutdown", testNickname), nil)
+	suite.Require().NoError(err)
+	defer ws.Close()
+	suite.readFrame(ws, models.FrameChatLoaded)
+
+	room, found := ctrl.GetRoom("shutdown")
+	suite.Require().True(found)
+	room.Worker.Pause()
+	suite.NoError(ws.WriteJSON(models.Frame{Type: models.FrameMessage, Ref: "1", Content: "in flight"}))
+	suite.readFrame(ws, models.FrameAck)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	suite.NoError(ctrl.Shutdown(ctx))
+
+	suite.Equal("in flight", suite.readFrame(ws, models.FrameMessage).Message.Content, "queued messages are delivered before closing")
+	_, _, err = ws.ReadMessage()
+	suite.True(websocket.IsCloseError(err, websocket.CloseServiceRestart), "got %v", err)
+	suite.Contains(err.Error(), shutdownReason)
 }
 
 func TestHandlersTestSuite(t *testing.T) {
-	suite.Run(t, new(HandlersTestSuite))
+	suite.Run(t, &HandlersTestSuite{newStore: func() (repo.Store, error) {
+		return repo.NewRepo(":memory:")
+	}})
+}
+
+func TestHandlersTestSuiteMemoryStore(t *testing.T) {
+	suite.Run(t, &HandlersTestSuite{newStore: func() (repo.Store, error) {
+		return repo.NewMemoryStore(), nil
+	}})
 }
@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// janitor prunes the messages expired by the retention policies.
+type janitor struct {
+	// run serializes the runs of the ticker and of the admin API.
+	run sync.Mutex
+
+	mu    sync.Mutex
+	stats models.RetentionStats
+	rooms map[string]*models.RoomPruneStats
+}
+
+// WithRetention sets the global retention policy, which fills in the bounds
+// a room policy leaves unset, and how often the janitor prunes messages.
+func WithRetention(policy models.RetentionPolicy, interval time.Duration) Option {
+	return func(c *Controller) error {
+		if policy.MaxAge < 0 || policy.MaxCount < 0 || policy.MaxBytes < 0 {
+			return errors.New("retention bounds cannot be negative")
+		}
+		if interval <= 0 {
+			return errors.New("retention interval must be positive")
+		}
+		c.retention = policy
+		c.retentionInterval = interval
+		return nil
+	}
+}
+
+func (c *Controller) runJanitor() {
+	ticker := time.NewTicker(c.retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.pruneMessages()
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// pruneMessages applies the retention policies to every stored room, in
+// batches of repo.DefaultPruneBatch so writers are never blocked for long.
+func (c *Controller) pruneMessages() {
+	c.janitor.run.Lock()
+	defer c.janitor.run.Unlock()
+
+	now := time.Now().UTC()
+	rooms, err := c.repo.GetRooms()
+	if err != nil {
+		c.janitor.failed(now, err)
+		return
+	}
+
+	total := int64(0)
+	seen := map[string]bool{}
+	for _, room := range rooms {
+		seen[room.ID] = true
+		policy := room.Retention.Or(c.retention)
+		pruned := int64(0)
+		for !room.LegalHold && c.ctx.Err() == nil {
+			n, err := c.repo.PruneMessages(room.ID, policy, now, repo.DefaultPruneBatch)
+			pruned += n
+			if err != nil {
+				c.janitor.failed(now, err)
+				break
+			}
+			if n < repo.DefaultPruneBatch {
+				break
+			}
+		}
+		if pruned > 0 {
+			log.Printf("pruned %d expired messages from room %s", pruned, room.ID)
+		}
+		total += pruned
+		c.janitor.roomPruned(room.ID, policy, room.LegalHold, pruned, now)
+	}
+
+	c.janitor.mu.Lock()
+	defer c.janitor.mu.Unlock()
+	for room := range c.janitor.rooms {
+		if !seen[room] {
+			delete(c.janitor.rooms, room)
+		}
+	}
+	c.janitor.stats.Runs++
+	c.janitor.stats.LastRunAt = &now
+	c.janitor.stats.LastPruned = total
+	c.janitor.stats.Pruned += total
+}
+
+func (j *janitor) failed(at time.Time, err error) {
+	log.Printf("error pruning expired messages: %v", err)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats.LastError = err.Error()
+	j.stats.LastErrorAt = &at
+}
+
+func (j *janitor) roomPruned(room string, policy models.RetentionPolicy, legalHold bool, pruned int64, at time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stats, found := j.rooms[room]
+	if !found {
+		stats = &models.RoomPruneStats{Room: room}
+		j.rooms[room] = stats
+	}
+	stats.Policy = policy
+	stats.LegalHold = legalHold
+	stats.Pruned += pruned
+	if pruned > 0 {
+		stats.LastPrunedAt = &at
+	}
+}
+
+func (c *Controller) retentionStats() models.RetentionStats {
+	c.janitor.mu.Lock()
+	defer c.janitor.mu.Unlock()
+	stats := c.janitor.stats
+	stats.Policy = c.retention
+	stats.Interval = c.retentionInterval.String()
+	stats.Rooms = make([]models.RoomPruneStats, 0, len(c.janitor.rooms))
+	for _, room := range c.janitor.rooms {
+		stats.Rooms = append(stats.Rooms, *room)
+	}
+	sort.Slice(stats.Rooms, func(i, j int) bool { return stats.Rooms[i].Room < stats.Rooms[j].Room })
+	return stats
+}
+
+// GetRetention godoc
+//
+//	@Summary		Get retention stats
+//	@Description	Show the global retention policy and how many messages the janitor pruned, overall and from each room, with the room policies it applied
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{object}	models.RetentionStats
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		403	{object}	map[string]string{}
+//	@Router			/api/v1/admin/retention [get]
+func (c *Controller) GetRetention(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.retentionStats())
+}
+
+// PruneMessages godoc
+//
+//	@Summary		Prune expired messages
+//	@Description	Run the janitor now rather than waiting for its next run
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{object}	models.RetentionStats
+//	@Failure		401	{object}	map[string]string{}
+//	@Failure		403	{object}	map[string]string{}
+//	@Router			/api/v1/admin/retention/prune [post]
+func (c *Controller) PruneMessages(ctx *gin.Context) {
+	c.pruneMessages()
+	ctx.JSON(http.StatusOK, c.retentionStats())
+}
+
+// SetRoomRetention godoc
+//
+//	@Summary		Set a room retention
+//	@Description	Replace the retention policy of a room, whose unset bounds are taken from the global policy, and its legal hold, which exempts it from pruning
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			room		path		string					true	"Room name"
+//	@Param			retention	body		models.RoomRetention	true	"Retention"
+//	@Success		200			{object}	models.RoomRetention
+//	@Failure		400			{object}	map[string]string{}
+//	@Failure		401			{object}	map[string]string{}
+//	@Failure		403			{object}	map[string]string{}
+//	@Failure		404			{object}	map[string]string{}
+//	@Failure		500			{object}	map[string]string{}
+//	@Router			/api/v1/admin/rooms/{room}/retention [put]
+func (c *Controller) SetRoomRetention(ctx *gin.Context) {
+	var retention models.RoomRetention
+	if err := ctx.ShouldBindJSON(&retention); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	policy := retention.Retention
+	if policy.MaxAge < 0 || policy.MaxCount < 0 || policy.MaxBytes < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "retention bounds cannot be negative"})
+		return
+	}
+
+	found, err := c.repo.SetRoomRetention(ctx.Param("room"), retention)
+	if err != nil {
+		log.Printf("error setting %s room retention: %v", ctx.Param("room"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set room retention"})
+		return
+	}
+	if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	ctx.JSON(http.StatusOK, retention)
+}
@@ -1,20 +1,92 @@
 package controller
 
 import (
+	"log"
+	"time"
+
 	"chat-app/internal/models"
+	"chat-app/pkg/queue"
 )
 
-func (c *Controller) NewRoom(roomID string) *models.Room {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	room := models.NewRoom(roomID)
+// newRoom creates a room in memory; its worker is started by AcquireRoom.
+// c.mu must be held.
+func (c *Controller) newRoom(roomID string) *models.Room {
+	room := models.NewRoom(roomID, queue.WithDeadLetters(c.deadLetters))
 	c.Rooms[roomID] = room
+	c.workers.Add(room.Worker)
 	return room
 }
 
+// AcquireRoom returns the room in memory, creating it when missing, and
+// marks it bound so the reaper leaves it alone until room.Unbind. It reports
+// whether the room was created. Stored rooms are only loaded this way, on
+// demand.
+func (c *Controller) AcquireRoom(roomID string) (*models.Room, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	room, found := c.Rooms[roomID]
+	if !found {
+		room = c.newRoom(roomID)
+	}
+	room.Worker.Start(c.ctx)
+	room.Bind()
+	return room, !found
+}
+
+// broadcast queues task on the room worker, making sure it runs. A full
+// queue, as a paused worker ends up with, drops the task: the messages are
+// stored, so clients catch up by binding again with since.
+func (c *Controller) broadcast(room *models.Room, task queue.Task) {
+	room.Worker.Start(c.ctx)
+	if err := room.Worker.Enqueue(task); err != nil {
+		log.Printf("dropped broadcast to %s room: %v", room.ID, err)
+	}
+}
+
 func (c *Controller) GetRoom(roomID string) (*models.Room, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	room, found := c.Rooms[roomID]
 	return room, found
 }
+
+// ListRooms returns a snapshot of the rooms in memory.
+func (c *Controller) ListRooms() []*models.Room {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make([]*models.Room, 0, len(c.Rooms))
+	for _, room := range c.Rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// reapRooms evicts the rooms nobody has bound to for roomIdleTimeout until
+// the controller stops. An evicted room is rebuilt from the repo on its next
+// bind.
+func (c *Controller) reapRooms() {
+	ticker := time.NewTicker(c.roomIdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.evictIdleRooms()
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Controller) evictIdleRooms() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for roomID, room := range c.Rooms {
+		if room.IdleFor() < c.roomIdleTimeout {
+			continue
+		}
+		delete(c.Rooms, roomID)
+		c.workers.Remove(room.Worker.Name)
+		room.Worker.Stop()
+		log.Printf("evicted idle room %s", roomID)
+	}
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Search godoc
+//
+//	@Summary		Search messages
+//	@Description	Full-text search of the messages of every room the caller can read, which is any room for signed-in users and guests alike, as with the room history. Hits come newest first with an HTML-escaped snippet where the matching words are wrapped in <mark> tags; "before" pages back from the last hit.
+//	@Tags			room
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			q		query		string	true	"Words every hit must contain"
+//	@Param			room	query		string	false	"Only messages of this room"
+//	@Param			author	query		string	false	"Only messages of this nickname"
+//	@Param			from	query		string	false	"Only messages posted at or after this RFC 3339 time"
+//	@Param			to		query		string	false	"Only messages posted before this RFC 3339 time"
+//	@Param			before	query		string	false	"Only messages older than this message ID"
+//	@Param			limit	query		int		false	"Page size (default 50, max 200)"
+//	@Success		200		{object}	models.SearchPage
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/search [get]
+func (c *Controller) Search(ctx *gin.Context) {
+	query := repo.SearchQuery{
+		Text:   ctx.Query("q"),
+		Room:   ctx.Query("room"),
+		Author: ctx.Query("author"),
+		Before: ctx.Query("before"),
+	}
+	if query.Text == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	for param, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		value := ctx.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 time"})
+			return
+		}
+		*bound = t
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		query.Limit = n
+	}
+
+	page, err := c.repo.SearchMessages(query)
+	if err != nil {
+		log.Printf("error searching msgs for %q: %v", query.Text, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search msgs"})
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
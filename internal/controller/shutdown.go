@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+const shutdownReason = "server restarting"
+
+// Shutdown stops the controller once the HTTP server stopped accepting
+// requests: it drains the bot and room workers so the messages in flight
+// reach the sockets, closes every socket with a "server restarting" close
+// frame and stops the workers. It gives up waiting when ctx is done, and
+// returns its error then.
+func (c *Controller) Shutdown(ctx context.Context) error {
+	defer c.Cancel()
+
+	// bot replies are broadcast by the room workers, so drain the bot first
+	err := c.botWorker.Drain(ctx)
+	if err != nil {
+		log.Printf("error draining %s worker: %v", c.botWorker.Name, err)
+	}
+	for _, room := range c.ListRooms() {
+		if drainErr := room.Worker.Drain(ctx); drainErr != nil {
+			log.Printf("error draining %s worker: %v", room.Worker.Name, drainErr)
+			err = drainErr
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultDrainTimeout)
+	}
+	var wg sync.WaitGroup
+	for _, room := range c.ListRooms() {
+		for _, client := range room.Clients() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := client.Shutdown(websocket.CloseServiceRestart, shutdownReason, deadline)
+				if err != nil && !errors.Is(err, models.ErrClientClosed) {
+					log.Printf("error closing %s socket in room %s: %v", client.Nickname, room.ID, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
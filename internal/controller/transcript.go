@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+
+	"chat-app/internal/transcript"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportRoom godoc
+//
+//	@Summary		Export a room history
+//	@Description	Download every message of a room, oldest first, as JSONL (one message per line, which the import endpoint takes back), plain text or an HTML page. The messages are streamed from the database rather than loaded at once.
+//	@Tags			room
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Produce		plain
+//	@Produce		html
+//	@Param			room	path		string	true	"Room name"
+//	@Param			format	query		string	false	"Export format"	Enums(jsonl, txt, html)	default(jsonl)
+//	@Success		200		{string}	string
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		404		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/rooms/{room}/export [get]
+func (c *Controller) ExportRoom(ctx *gin.Context) {
+	format, err := transcript.ParseFormat(ctx.Query("format"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	roomID := ctx.Param("room")
+	if _, found, err := c.repo.GetRoom(roomID); err != nil {
+		log.Printf("error getting %s room: %v", roomID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get room from db"})
+		return
+	} else if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+
+	ctx.Header("Content-Type", format.ContentType())
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": roomID + "." + string(format)}))
+	if err := transcript.Export(c.repo, roomID, format, ctx.Writer); err != nil {
+		log.Printf("error exporting %s room msgs: %v", roomID, err)
+		// Once the export started streaming, all that is left is to cut it short.
+		if !ctx.Writer.Written() {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export room msgs"})
+		}
+	}
+}
+
+// ImportRoom godoc
+//
+//	@Summary		Import a room history
+//	@Description	Store the messages of a JSONL export in a room, creating it when missing. Authors and timestamps are kept and the messages sort among the room history by timestamp; messages an earlier import of the same export stored are skipped, so a failed import can be retried. The import stops at the first malformed line, keeping the messages before it.
+//	@Tags			admin
+//	@Security		BearerAuth
+//	@Accept			plain
+//	@Produce		json
+//	@Param			room	path		string	true	"Room name"
+//	@Param			export	body		string	true	"JSONL export"
+//	@Success		200		{object}	models.ImportResult
+//	@Failure		400		{object}	map[string]string{}
+//	@Failure		401		{object}	map[string]string{}
+//	@Failure		403		{object}	map[string]string{}
+//	@Failure		500		{object}	map[string]string{}
+//	@Router			/api/v1/admin/rooms/{room}/import [post]
+func (c *Controller) ImportRoom(ctx *gin.Context) {
+	result, err := transcript.Import(c.repo, ctx.Param("room"), ctx.Request.Body)
+	var lineErr *transcript.LineError
+	switch {
+	case errors.As(err, &lineErr):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "imported": result.Imported, "skipped": result.Skipped})
+		return
+	case err != nil:
+		log.Printf("error importing %s room msgs: %v", ctx.Param("room"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import room msgs", "imported": result.Imported, "skipped": result.Skipped})
+		return
+	}
+	log.Printf("imported %d msgs into room %s, skipped %d", result.Imported, result.Room, result.Skipped)
+	ctx.JSON(http.StatusOK, result)
+}
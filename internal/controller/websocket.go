@@ -2,8 +2,9 @@ package controller
 
 import (
 	"chat-app/internal/models"
-	"chat-app/pkg/bot"
+	"chat-app/internal/repo"
 	"chat-app/pkg/utils"
+	"encoding/json"
 	"log"
 	"net/http"
 	"strings"
@@ -11,21 +12,46 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
 )
 
-// SendMessage sends a message to a specific room
+var errContentTooLong = errors.New("content is too long")
+
+// checkContent rejects the contents longer than maxMessageSize bytes.
+func (c *Controller) checkContent(content string) error {
+	if len(content) > c.maxMessageSize {
+		return errContentTooLong
+	}
+	return nil
+}
+
+// readLimit bounds an inbound frame: its content may take six times
+// maxMessageSize once escaped in JSON, next to the other frame fields.
+func (c *Controller) readLimit() int64 {
+	return int64(6*c.maxMessageSize + 1024)
+}
+
+// SendMessage sends a message to a specific room; kept as a shim for clients
+// that still open a socket per message instead of using the bound one.
 //
 //	@Summary		Send a message to a specific room
-//	@Description	Send a message to a specific room identified by room ID
+//	@Description	Send a message to a specific room identified by room ID. Legacy shim; prefer sending a "message" frame over the bound websocket.
 //	@Tags			websocket
+//	@Security		BearerAuth
+//	@Deprecated
 //	@Accept			json
 //	@Produce		json
 //	@Param			room		path		string			true	"room ID"
-//	@Param			nickname	path		string			true	"nickname"
+//	@Param			nickname	path		string			true	"nickname; must be the authenticated user, or the nickname a guest was suffixed to in the room"
+//	@Param			token		query		string			false	"Access token, when not sent in the Authorization header"
+//	@Param			key			query		string			false	"Idempotency key; a retried send with the same key is stored and broadcast only once"
 //	@Param			payload		body		models.Message	true	"Payload with nickname and message"
 //	@Success		200			{object}	map[string]string{}
 //	@Failure		400			{object}	map[string]string{}
+//	@Failure		401			{object}	map[string]string{}
+//	@Failure		403			{object}	map[string]string{}
 //	@Failure		404			{object}	map[string]string{}
+//	@Failure		409			{object}	map[string]string{}
 //	@Failure		500			{object}	map[string]string{}
 //	@Router			/api/v1/rooms/{room}/send [get]
 func (c *Controller) SendMessage(ctx *gin.Context) {
@@ -35,9 +61,13 @@ func (c *Controller) SendMessage(ctx *gin.Context) {
 		return
 	}
 
+	// the author is the session nickname, or the one a guest was suffixed
+	// to when binding, as long as the session holds it in the room
+	session := sessionFrom(ctx)
 	nickname := ctx.Param("nickname")
-	if nickname == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "nickname path parameter is required"})
+	room, found := c.GetRoom(roomID)
+	if nickname != session.Nickname && (!found || !room.Holds(nickname, session.Owner())) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "nickname does not match the authenticated user"})
 		return
 	}
 
@@ -46,70 +76,84 @@ func (c *Controller) SendMessage(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "content query parameter is required"})
 		return
 	}
-
-	room, found := c.GetRoom(roomID)
-	if !found {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+	if err := c.checkContent(content); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	message := models.Message{
-		Nickname:  nickname,
-		Room:      roomID,
-		Timestamp: time.Now().UTC(),
-		Content:   content,
+	if !found {
+		// a stored room nobody is bound to is not loaded
+		if _, stored, err := c.repo.GetRoom(roomID); err != nil {
+			log.Printf("error getting %s room: %v", roomID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get room from db"})
+			return
+		} else if !stored {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
 	}
-
-	if strings.HasPrefix(content, "/") {
-		botMsg, err := bot.ProcessCMD(content)
+	if session.Guest {
+		// a guest token may predate the registration of its nickname
+		registered, err := c.nicknameRegistered(nickname)
 		if err != nil {
-			log.Println("bot cmd process err", err)
+			log.Printf("error getting %s user: %v", nickname, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user from db"})
+			return
+		}
+		if registered {
+			ctx.JSON(http.StatusConflict, gin.H{"error": errNicknameTaken.Error()})
 			return
 		}
-		botMsg.Room = roomID
-		defer func() {
-			room.Worker.TaskQueue <- NewMsgTask(botMsg, room.Connection)
-		}()
 	}
+	room, _ = c.AcquireRoom(roomID)
+	defer room.Unbind()
+	if !room.Reserve(nickname, session.Owner()) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": errNicknameTaken.Error()})
+		return
+	}
+	defer room.Release(nickname, session.Owner())
 
-	room.Worker.TaskQueue <- NewMsgTask(message, room.Connection)
-
-	if err := c.repo.AddMessage(message); err != nil {
-		log.Printf("error adding message to the database: %v", err)
+	if _, err := c.postMessage(room, nickname, session.Owner(), content, ctx.Query("key")); err != nil {
+		log.Printf("error posting message to %s room: %v", roomID, err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add message to the database"})
 		return
 	}
-	log.Printf("Message sent to %s room: %s", roomID, message.Content)
 	ctx.Done()
 }
 
 // BindRoom godoc
 //
 //	@Summary		Bind to chat room
-//	@Description	Bind to a given chat room
+//	@Description	Bind to a given chat room. The socket receives JSON frames such as {"id":"7","type":"message","message":{...}} and accepts frames such as {"type":"message","ref":"1","content":"hi"} or {"type":"typing"}; each message frame is answered with an ack frame echoing its ref. Negotiating the "chat.text" subprotocol switches outbound frames to the legacy preformatted text lines. Join and leave frames announce the first connection of a nickname to the room and the close of its last one. The first frame is a welcome frame with the nickname used in the room, which differs from a guest's requested one when it was suffixed.
 //	@Tags			websocket
+//	@Security		BearerAuth
 //	@Accept			json
 //	@Produce		json
 //	@Param			room		path		string	true	"Room name"
-//	@Param			nickname	query		string	true	"Nickname"
+//	@Param			token		query		string	false	"Access token, when not sent in the Authorization header or as a token.<access token> subprotocol; messages are posted as its user"
+//	@Param			since		query		string	false	"Last seen message ID or RFC 3339 timestamp; only the messages posted after it are replayed, followed by a sync_complete frame"
+//	@Param			on_conflict	query		string	false	"What to do when a guest nickname is already in use in the room: suffix it (default) or reject the bind. Registered users are always rejected while a guest bound before the username was registered holds it"	Enums(suffix, reject)
 //	@Success		200			{string}	string	"Connected"
+//	@Failure		400			{object}	map[string]string{}
+//	@Failure		401			{object}	map[string]string{}
+//	@Failure		409			{object}	map[string]string{}
 //	@Router			/api/v1/rooms/{room}/bind [get]
 func (c *Controller) BindRoom(ctx *gin.Context) {
 	roomID := ctx.Param("room")
 
-	conn, err := utils.NewSocketConnection(ctx.Writer, ctx.Request)
-	if err != nil {
-		log.Printf("error establishing websocket connection for room %s: %v", roomID, err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to establish websocket connection"})
+	session := sessionFrom(ctx)
+
+	onConflict := ctx.DefaultQuery("on_conflict", "suffix")
+	if onConflict != "suffix" && onConflict != "reject" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "on_conflict must be suffix or reject"})
 		return
 	}
 
-	room, exists := c.GetRoom(roomID)
-	if !exists {
-		room = c.NewRoom(roomID)
+	room, created := c.AcquireRoom(roomID)
+	defer room.Unbind()
+	if created {
 		log.Printf("new websocket connection established for room: %s", roomID)
-		go room.Worker.StartWorker(c.ctx)
-		err = c.repo.AddRoom(room.ID)
+		err := c.repo.AddRoom(room.ID)
 		if err != nil {
 			log.Printf("error room to db %s: %v", room.ID, err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add room to db"})
@@ -117,23 +161,270 @@ func (c *Controller) BindRoom(ctx *gin.Context) {
 		}
 	}
 
-	room.AddConnection(conn)
+	nickname, err := c.reserveNickname(room, session, onConflict == "suffix")
+	if errors.Is(err, errNicknameTaken) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("error reserving nickname %s in room %s: %v", session.Nickname, roomID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reserve nickname"})
+		return
+	}
+	defer room.Release(nickname, session.Owner())
+
+	conn, err := utils.NewSocketConnection(ctx.Writer, ctx.Request, models.SubprotocolJSON, models.SubprotocolText)
+	if err != nil {
+		log.Printf("error establishing websocket connection for room %s: %v", roomID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to establish websocket connection"})
+		return
+	}
+	client := models.NewClient(nickname, session.Owner(), conn, c.sendBuffer, c.overflow)
+	go client.WritePump()
+
+	welcome := models.NewFrame(models.FrameWelcome)
+	welcome.Nickname = nickname
+	c.send(client, welcome)
+
+	client.StartSync()
+	if room.AddConnection(client) {
+		c.announce(room, models.FrameJoin, client)
+	}
+
+	done := models.NewFrame(models.FrameChatLoaded)
+	if since := ctx.Query("since"); since != "" {
+		done = models.NewFrame(models.FrameSyncComplete)
+		err = c.replaySince(room, client, since)
+	} else {
+		err = c.replayLatest(room, client)
+	}
+	if err != nil {
+		log.Printf("error getting %s room msgs: %v", room.ID, err)
+	}
+	if err = client.FinishSync(done); err != nil {
+		log.Printf("failed do send history message to socket; msg %s - err: %v", done.Type, err)
+	}
+
+	c.readLoop(room, client)
+}
+
+func (c *Controller) replayLatest(room *models.Room, client *models.Client) error {
+	msgs, err := c.repo.GetMessages(room.ID)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if err = client.Replay(models.NewMessageFrame(msg)); err != nil {
+			log.Printf("failed do send history message to socket; msg %s - err: %v", msg.Fmt(), err)
+		}
+	}
+	return nil
+}
+
+// replaySince replays every message posted after since, which is either a
+// message ID or an RFC 3339 timestamp.
+func (c *Controller) replaySince(room *models.Room, client *models.Client, since string) error {
+	query := repo.MessageQuery{Limit: repo.MaxMessageLimit}
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		query.Since = t.UTC()
+	} else {
+		query.After = since
+	}
 
-	if exists {
-		msgs, err := c.repo.GetMessages(room.ID)
+	for {
+		page, err := c.repo.ListMessages(room.ID, query)
 		if err != nil {
-			log.Printf("error getting %s room msgs: %v", room.ID, err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get room msgs from db"})
+			return err
+		}
+		for _, msg := range page.Messages {
+			if err = client.Replay(models.NewMessageFrame(msg)); err != nil {
+				return errors.Wrap(err, "failed to replay message")
+			}
+		}
+		if !page.HasMore {
+			return nil
+		}
+		query.After = page.Messages[len(page.Messages)-1].ID
+	}
+}
+
+// readLoop consumes inbound frames until the socket is closed or stops
+// answering pings, then removes the client from the room.
+func (c *Controller) readLoop(room *models.Room, client *models.Client) {
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		client.Close()
+		if room.RemoveConnection(client) {
+			c.announce(room, models.FrameLeave, client)
+		}
+	}()
+
+	extend := func() error {
+		return client.Conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	}
+	if err := extend(); err != nil {
+		return
+	}
+	client.Conn.SetReadLimit(c.readLimit())
+	client.Conn.SetPongHandler(func(string) error { return extend() })
+	go c.keepalive(client, stop)
+
+	for {
+		_, data, err := client.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("error reading from %s socket in room %s: %v", client.Nickname, room.ID, err)
+			}
+			return
+		}
+		client.Touch()
+		if err := extend(); err != nil {
 			return
 		}
-		for _, msg := range msgs {
-			m := msg.Fmt()
-			if err = conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
-				log.Printf("failed do send history message to socket; msg %s - err: %v", m, err)
+
+		var frame models.Frame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			c.ack(client, models.Frame{}, errors.New("invalid frame"))
+			continue
+		}
+		c.handleFrame(room, client, frame)
+	}
+}
+
+// keepalive pings client until stop is closed; a peer that does not answer
+// lets the read deadline expire, which ends its read loop.
+func (c *Controller) keepalive(client *models.Client, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := client.Ping(time.Now().Add(c.pingPeriod)); err != nil {
+				client.Close()
+				return
 			}
+		case <-stop:
+			return
 		}
 	}
-	if err = conn.WriteMessage(websocket.TextMessage, []byte("chat loaded")); err != nil {
-		log.Printf("failed do send history message to socket; msg %s - err: %v", "chat loaded", err)
+}
+
+func (c *Controller) handleFrame(room *models.Room, client *models.Client, frame models.Frame) {
+	switch frame.Type {
+	case models.FrameMessage:
+		if frame.Content == "" {
+			c.ack(client, frame, errors.New("content is required"))
+			return
+		}
+		if err := c.checkContent(frame.Content); err != nil {
+			c.ack(client, frame, err)
+			return
+		}
+		message, err := c.postMessage(room, client.Nickname, client.Owner, frame.Content, frame.Key)
+		if err != nil {
+			log.Printf("error posting message to %s room: %v", room.ID, err)
+			c.ack(client, frame, errors.New("failed to send message"))
+			return
+		}
+		ack := models.NewFrame(models.FrameAck)
+		ack.Ref = frame.Ref
+		ack.Message = &message
+		c.send(client, ack)
+	case models.FrameEdit:
+		if frame.MessageID == "" || frame.Content == "" {
+			c.ack(client, frame, errors.New("message_id and content are required"))
+			return
+		}
+		message, err := c.editMessage(room.ID, client.Owner, frame.MessageID, frame.Content)
+		if err != nil {
+			if editStatus(err) == http.StatusInternalServerError {
+				log.Printf("error editing message %s in %s room: %v", frame.MessageID, room.ID, err)
+				err = errors.New("failed to edit message")
+			}
+			c.ack(client, frame, err)
+			return
+		}
+		ack := models.NewFrame(models.FrameAck)
+		ack.Ref = frame.Ref
+		ack.Message = &message
+		c.send(client, ack)
+	case models.FrameTyping:
+		typing := models.NewFrame(models.FrameTyping)
+		typing.Nickname = client.Nickname
+		for _, peer := range room.Clients() {
+			if peer == client {
+				continue
+			}
+			if err := peer.Send(typing); err != nil {
+				log.Printf("error sending typing frame to %s: %v", peer.Nickname, err)
+			}
+		}
+	case models.FrameAck:
+		// clients may acknowledge delivered frames; nothing is tracked yet
+	default:
+		c.ack(client, frame, errors.Errorf("unknown frame type %q", frame.Type))
+	}
+}
+
+// announce broadcasts a presence event about client to the rest of the room
+// through its worker.
+func (c *Controller) announce(room *models.Room, frameType models.FrameType, client *models.Client) {
+	frame := models.NewFrame(frameType)
+	frame.Nickname = client.Nickname
+	peers := []*models.Client{}
+	for _, peer := range room.Clients() {
+		if peer != client {
+			peers = append(peers, peer)
+		}
+	}
+	c.broadcast(room, NewFrameTask(frame, peers))
+}
+
+func (c *Controller) ack(client *models.Client, frame models.Frame, err error) {
+	ack := models.NewFrame(models.FrameAck)
+	ack.Ref = frame.Ref
+	if err != nil {
+		ack.Error = err.Error()
+	}
+	c.send(client, ack)
+}
+
+func (c *Controller) send(client *models.Client, frame models.Frame) {
+	if err := client.Send(frame); err != nil {
+		log.Printf("error sending %s frame to %s: %v", frame.Type, client.Nickname, err)
+	}
+}
+
+// postMessage stores a message nickname posted on behalf of owner and
+// broadcasts it to the room, queueing bot commands to be answered. A retried
+// send reusing clientKey returns the original message without broadcasting
+// it again.
+func (c *Controller) postMessage(room *models.Room, nickname, owner, content, clientKey string) (models.Message, error) {
+	message, created, err := c.repo.AddMessage(models.Message{
+		ID:        utils.NewID(),
+		Nickname:  nickname,
+		Room:      room.ID,
+		Timestamp: time.Now().UTC(),
+		Content:   content,
+		ClientKey: clientKey,
+		Owner:     owner,
+	})
+	if err != nil {
+		return models.Message{}, errors.Wrap(err, "error adding message to the database")
+	}
+	if !created {
+		log.Printf("duplicate message %s from %s ignored in %s room", message.ID, nickname, room.ID)
+		return message, nil
+	}
+
+	c.broadcast(room, NewMsgTask(message, room.Clients()))
+	log.Printf("Message sent to %s room: %s", room.ID, message.Content)
+
+	if strings.HasPrefix(content, "/") {
+		if err := c.botWorker.Enqueue(c.newBotTask(room.ID, content)); err != nil {
+			return message, errors.Wrap(err, "bot cmd enqueue err")
+		}
 	}
+	return message, nil
 }
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Backup is a snapshot of the database taken by the admin API.
+type Backup struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
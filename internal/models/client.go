@@ -0,0 +1,265 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WriteWait is how long a single frame write may block before the peer is
+// considered dead.
+const WriteWait = 10 * time.Second
+
+var (
+	// ErrClientClosed is returned when sending to a client whose socket was closed.
+	ErrClientClosed = errors.New("client connection closed")
+	// ErrClientOverflow is returned when a client falls too far behind and is
+	// disconnected under OverflowDisconnect.
+	ErrClientOverflow = errors.New("client send buffer overflow")
+)
+
+// OverflowPolicy decides what happens to a client whose send buffer is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest buffered frame to make room.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDisconnect closes the socket of the slow consumer, which can
+	// catch up by binding again with since.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// Client is a websocket connection bound to a room on behalf of a nickname.
+// Frames are queued to a bounded buffer drained by WritePump, the single
+// writer gorilla/websocket supports, so broadcasting never waits on the peer.
+type Client struct {
+	Nickname string
+	// Owner identifies who holds the nickname reservation in the room: a
+	// user ID, or the session of a guest.
+	Owner string
+	Conn  *websocket.Conn
+	// Legacy clients negotiated SubprotocolText and get Message.Fmt lines.
+	Legacy bool
+	// JoinedAt is when the connection was bound to the room.
+	JoinedAt time.Time
+
+	// lastActive is the UnixNano time of the last inbound frame.
+	lastActive atomic.Int64
+
+	out      chan Frame
+	overflow OverflowPolicy
+	// closeMessage is the payload of the close frame written by Shutdown.
+	closeMessage []byte
+	done         chan struct{}
+	closed       atomic.Bool
+
+	mu       sync.Mutex
+	syncing  bool
+	pending  []Frame
+	replayed map[string]bool
+}
+
+// NewClient returns a client buffering up to buffer outbound frames; they are
+// only written once WritePump runs.
+func NewClient(nickname, owner string, conn *websocket.Conn, buffer int, overflow OverflowPolicy) *Client {
+	client := &Client{
+		Nickname: nickname,
+		Owner:    owner,
+		Conn:     conn,
+		Legacy:   conn.Subprotocol() == SubprotocolText,
+		JoinedAt: time.Now().UTC(),
+		out:      make(chan Frame, buffer),
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+	client.lastActive.Store(client.JoinedAt.UnixNano())
+	return client
+}
+
+// Touch records activity from the client, keeping it from going idle.
+func (c *Client) Touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *Client) LastActive() time.Time {
+	return time.Unix(0, c.lastActive.Load()).UTC()
+}
+
+// WritePump writes the buffered frames until the client is closed; a failed
+// write closes it.
+func (c *Client) WritePump() {
+	for {
+		select {
+		case frame := <-c.out:
+			if frame.Type == frameClose {
+				c.Conn.WriteControl(websocket.CloseMessage, c.closeMessage, time.Now().Add(WriteWait))
+				c.Close()
+				return
+			}
+			if err := c.write(frame); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// StartSync holds back frames passed to Send until FinishSync, so messages
+// broadcast while the history is replayed are not interleaved with it.
+func (c *Client) StartSync() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncing = true
+	c.replayed = map[string]bool{}
+}
+
+// Replay queues a history frame ahead of the frames held back by StartSync,
+// waiting for room in the buffer rather than applying the overflow policy.
+func (c *Client) Replay(frame Frame) error {
+	c.mu.Lock()
+	if frame.Message != nil {
+		c.replayed[frame.Message.ID] = true
+	}
+	c.mu.Unlock()
+	return c.push(frame)
+}
+
+// FinishSync queues done and then the frames held back since StartSync,
+// skipping message frames the replay already delivered; other frames about
+// those messages, such as edits, are kept. Frames sent meanwhile keep being
+// held back until none is left, so the order is preserved.
+func (c *Client) FinishSync(done Frame) error {
+	if err := c.push(done); err != nil {
+		return err
+	}
+	for {
+		c.mu.Lock()
+		pending, replayed := c.pending, c.replayed
+		c.pending = nil
+		if len(pending) == 0 {
+			c.syncing, c.replayed = false, nil
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+
+		for _, frame := range pending {
+			if frame.Type == FrameMessage && frame.Message != nil && replayed[frame.Message.ID] {
+				continue
+			}
+			if err := c.push(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Send queues frame without blocking, applying the overflow policy when the
+// buffer is full. While syncing, the frames held back are bound by the
+// buffer size too.
+func (c *Client) Send(frame Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if c.syncing {
+		if len(c.pending) >= cap(c.out) {
+			if c.overflow == OverflowDisconnect {
+				c.Close()
+				return ErrClientOverflow
+			}
+			c.pending = c.pending[1:]
+		}
+		c.pending = append(c.pending, frame)
+		return nil
+	}
+
+	select {
+	case c.out <- frame:
+		return nil
+	default:
+	}
+	if c.overflow == OverflowDisconnect {
+		c.Close()
+		return ErrClientOverflow
+	}
+	select {
+	case <-c.out:
+	default:
+	}
+	select {
+	case c.out <- frame:
+	default:
+	}
+	return nil
+}
+
+// push queues frame, waiting for room in the buffer.
+func (c *Client) push(frame Frame) error {
+	select {
+	case c.out <- frame:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	}
+}
+
+// write writes frame in the format negotiated by the client.
+func (c *Client) write(frame Frame) error {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(WriteWait)); err != nil {
+		return err
+	}
+	if !c.Legacy {
+		return c.Conn.WriteJSON(frame)
+	}
+	text, ok := frame.Text()
+	if !ok {
+		return nil
+	}
+	return c.Conn.WriteMessage(websocket.TextMessage, []byte(text))
+}
+
+// Close closes the socket, making its read loop and WritePump return and
+// later sends fail fast with ErrClientClosed.
+func (c *Client) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	close(c.done)
+	return c.Conn.Close()
+}
+
+// Shutdown closes the socket with a close frame carrying code and reason,
+// written after the frames already buffered; the socket is closed anyway at
+// deadline.
+func (c *Client) Shutdown(code int, reason string, deadline time.Time) error {
+	c.closeMessage = websocket.FormatCloseMessage(code, reason)
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	defer c.Close()
+
+	select {
+	case c.out <- Frame{Type: frameClose}:
+	case <-c.done:
+		return ErrClientClosed
+	case <-timeout.C:
+		return errors.New("timed out closing the client")
+	}
+	select {
+	case <-c.done:
+		return nil
+	case <-timeout.C:
+		return errors.New("timed out closing the client")
+	}
+}
+
+// Ping writes a ping control frame, which may run alongside a frame write.
+func (c *Client) Ping(deadline time.Time) error {
+	return c.Conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
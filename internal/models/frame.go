@@ -0,0 +1,92 @@
+package models
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+type FrameType string
+
+const (
+	FrameMessage    FrameType = "message"
+	FrameTyping     FrameType = "typing"
+	FrameAck        FrameType = "ack"
+	FrameChatLoaded FrameType = "chat_loaded"
+	// FrameWelcome is the first frame of a bound socket and carries the
+	// nickname the client was given in the room.
+	FrameWelcome FrameType = "welcome"
+	// FrameSyncComplete ends the replay of the messages missed since a resumed bind.
+	FrameSyncComplete FrameType = "sync_complete"
+	// FrameJoin and FrameLeave announce a nickname's first connection to the
+	// room and the close of its last one.
+	FrameJoin  FrameType = "join"
+	FrameLeave FrameType = "leave"
+	// FrameEdit asks to replace the content of one of the client's messages;
+	// FrameEdited broadcasts the message once edited.
+	FrameEdit   FrameType = "edit"
+	FrameEdited FrameType = "edited"
+
+	// frameClose asks the writer of a client to close its socket once the
+	// frames queued before are written; it is never sent as is.
+	frameClose FrameType = "close"
+)
+
+// Websocket subprotocols negotiated at bind time; JSON frames are the default
+// and the text protocol keeps the preformatted Message.Fmt lines.
+const (
+	SubprotocolJSON = "chat.json"
+	SubprotocolText = "chat.text"
+)
+
+var frameSeq atomic.Uint64
+
+// Frame is the JSON envelope exchanged over a bound room websocket.
+// Ref is chosen by the client on outbound frames and echoed back on the matching ack;
+// Key is the idempotency key of a message frame and survives retries;
+// MessageID is the message an edit frame replaces the content of.
+type Frame struct {
+	ID        string    `json:"id,omitempty"`
+	Type      FrameType `json:"type"`
+	Ref       string    `json:"ref,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	Nickname  string    `json:"nickname,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Message   *Message  `json:"message,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// NewFrame returns a server frame with a fresh ID.
+func NewFrame(frameType FrameType) Frame {
+	return Frame{
+		ID:   strconv.FormatUint(frameSeq.Add(1), 10),
+		Type: frameType,
+	}
+}
+
+func NewMessageFrame(msg Message) Frame {
+	frame := NewFrame(FrameMessage)
+	frame.Message = &msg
+	return frame
+}
+
+// NewEditedFrame announces the new content of an edited message.
+func NewEditedFrame(msg Message) Frame {
+	frame := NewFrame(FrameEdited)
+	frame.Message = &msg
+	return frame
+}
+
+// Text renders the frame for legacy text clients; frames without a text form
+// are not sent to them.
+func (f Frame) Text() (string, bool) {
+	switch f.Type {
+	case FrameMessage, FrameEdited:
+		if f.Message != nil {
+			return f.Message.Fmt(), true
+		}
+	case FrameChatLoaded, FrameSyncComplete:
+		return "chat loaded", true
+	}
+	return "", false
+}
@@ -3,25 +3,47 @@ package models
 import (
 	"fmt"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
-type Session struct {
-	Room     string
-	Nickname string
+// Message is a chat message; ClientKey is an optional idempotency key chosen
+// by the sender so retried sends are stored and broadcast only once.
+// EditedAt is set once its author edited it. Owner identifies the author as
+// Session.Owner does, so only they can edit it; it is not exposed.
+type Message struct {
+	ID        string     `json:"id"                   gorm:"primaryKey;index:idx_messages_room_id,priority:2"`
+	Room      string     `json:"room"                 gorm:"index:idx_messages_room_id,priority:1;uniqueIndex:idx_messages_client_key,where:client_key <> ''"`
+	Nickname  string     `json:"nickname"             gorm:"uniqueIndex:idx_messages_client_key"  binding:"required"`
+	Timestamp time.Time  `json:"timestamp"            gorm:"timestamp"`
+	Content   string     `json:"content"              gorm:"content"                              binding:"required"`
+	ClientKey string     `json:"client_key,omitempty" gorm:"uniqueIndex:idx_messages_client_key"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Owner     string     `json:"-"`
 }
 
-type Message struct {
-	Room      string    `json:"room"       gorm:"room"`
-	Nickname  string    `json:"nickname"   gorm:"nickname"  binding:"required"`
-	Timestamp time.Time `json:"timestamp"  gorm:"timestamp"`
-	Content   string    `json:"content"    gorm:"content"   binding:"required"`
+// MessageRevision is the content a message had before one of its edits;
+// Timestamp is when that content was posted, or written by an earlier edit.
+type MessageRevision struct {
+	ID        string    `json:"id"         gorm:"primaryKey"`
+	MessageID string    `json:"message_id" gorm:"index:idx_message_revisions_message_id,priority:1"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// EditRequest is the new content of an edited message.
+type EditRequest struct {
+	Content string `json:"content" binding:"required"`
 }
 
-type Rooms []Room
+type Rooms []*Room
 type Messages []Message
 
+// MessagePage is a window of room messages in ascending ID order; HasMore
+// reports whether further messages exist past the window in the paging direction.
+type MessagePage struct {
+	Messages Messages `json:"messages"`
+	HasMore  bool     `json:"has_more"`
+}
+
 func ToMap[T Custom](input []T) map[string]T {
 	valueMap := make(map[string]T)
 	for _, value := range input {
@@ -34,16 +56,51 @@ type Custom interface {
 	GetID() string
 }
 
-func (r Room) GetID() string {
+func (r *Room) GetID() string {
 	return r.ID
 }
 
-func (r *Room) AddConnection(conn *websocket.Conn) {
+func (m Message) GetID() string {
+	return m.ID
+}
+
+// AddConnection adds client to the room, reporting whether it is the first
+// connection of its nickname, i.e. whether the nickname just joined.
+func (r *Room) AddConnection(client *Client) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.Connection = append(r.Connection, conn)
+	joined := !r.connected(client.Nickname)
+	r.Connection = append(r.Connection, client)
+	return joined
+}
+
+// RemoveConnection removes client from the room, reporting whether it was the
+// last connection of its nickname, i.e. whether the nickname left.
+func (r *Room) RemoveConnection(client *Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, conn := range r.Connection {
+		if conn == client {
+			r.Connection = append(r.Connection[:i], r.Connection[i+1:]...)
+			return !r.connected(client.Nickname)
+		}
+	}
+	return false
+}
+
+func (r *Room) connected(nickname string) bool {
+	for _, conn := range r.Connection {
+		if conn.Nickname == nickname {
+			return true
+		}
+	}
+	return false
 }
 
 func (m Message) Fmt() string {
-	return fmt.Sprintf("[%s] %s: %s", m.Timestamp.Format("2006-01-02 15:04:05"), m.Nickname, m.Content)
+	line := fmt.Sprintf("[%s] %s: %s", m.Timestamp.Format("2006-01-02 15:04:05"), m.Nickname, m.Content)
+	if m.EditedAt != nil {
+		line += " (edited)"
+	}
+	return line
 }
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// IdleAfter is how long a member can go without sending a frame before it is
+// reported idle.
+const IdleAfter = 5 * time.Minute
+
+// Member is the presence of a nickname in a room, merged over all of its
+// connections.
+type Member struct {
+	Nickname    string    `json:"nickname"`
+	Connections int       `json:"connections"`
+	JoinedAt    time.Time `json:"joined_at"`
+	LastActive  time.Time `json:"last_active"`
+	Idle        bool      `json:"idle"`
+}
+
+// Members returns who is connected to the room, sorted by nickname.
+func (r *Room) Members() []Member {
+	byNickname := map[string]*Member{}
+	for _, client := range r.Clients() {
+		lastActive := client.LastActive()
+		member, found := byNickname[client.Nickname]
+		if !found {
+			byNickname[client.Nickname] = &Member{
+				Nickname:    client.Nickname,
+				Connections: 1,
+				JoinedAt:    client.JoinedAt,
+				LastActive:  lastActive,
+			}
+			continue
+		}
+		member.Connections++
+		if client.JoinedAt.Before(member.JoinedAt) {
+			member.JoinedAt = client.JoinedAt
+		}
+		if lastActive.After(member.LastActive) {
+			member.LastActive = lastActive
+		}
+	}
+
+	members := make([]Member, 0, len(byNickname))
+	for _, member := range byNickname {
+		member.Idle = time.Since(member.LastActive) > IdleAfter
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Nickname < members[j].Nickname })
+	return members
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RetentionPolicy bounds how much history a room keeps: messages older than
+// MaxAge, past the newest MaxCount or past the newest MaxBytes of content
+// are pruned. Zero fields set no bound.
+type RetentionPolicy struct {
+	MaxAge   time.Duration `json:"max_age"   swaggertype:"string" example:"720h"`
+	MaxCount int64         `json:"max_count"`
+	MaxBytes int64         `json:"max_bytes"`
+}
+
+// IsZero reports whether the policy keeps every message.
+func (p RetentionPolicy) IsZero() bool {
+	return p == RetentionPolicy{}
+}
+
+// Or returns the policy with its unset fields taken from fallback, as room
+// policies are completed by the global one.
+func (p RetentionPolicy) Or(fallback RetentionPolicy) RetentionPolicy {
+	if p.MaxAge == 0 {
+		p.MaxAge = fallback.MaxAge
+	}
+	if p.MaxCount == 0 {
+		p.MaxCount = fallback.MaxCount
+	}
+	if p.MaxBytes == 0 {
+		p.MaxBytes = fallback.MaxBytes
+	}
+	return p
+}
+
+type retentionPolicyJSON struct {
+	MaxAge   string `json:"max_age,omitempty"`
+	MaxCount int64  `json:"max_count"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+// MarshalJSON writes MaxAge as a duration string such as "720h0m0s".
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	out := retentionPolicyJSON{MaxCount: p.MaxCount, MaxBytes: p.MaxBytes}
+	if p.MaxAge != 0 {
+		out.MaxAge = p.MaxAge.String()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads MaxAge as a duration string such as "720h".
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) error {
+	var in retentionPolicyJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	policy := RetentionPolicy{MaxCount: in.MaxCount, MaxBytes: in.MaxBytes}
+	if in.MaxAge != "" {
+		maxAge, err := time.ParseDuration(in.MaxAge)
+		if err != nil {
+			return err
+		}
+		policy.MaxAge = maxAge
+	}
+	*p = policy
+	return nil
+}
+
+// RoomRetention is the retention of a stored room as set by the admins.
+type RoomRetention struct {
+	Retention RetentionPolicy `json:"retention"`
+	LegalHold bool            `json:"legal_hold"`
+}
+
+// RetentionStats describes the janitor pruning expired messages.
+type RetentionStats struct {
+	Policy      RetentionPolicy  `json:"policy"`
+	Interval    string           `json:"interval"`
+	Runs        int64            `json:"runs"`
+	LastRunAt   *time.Time       `json:"last_run_at,omitempty"`
+	LastPruned  int64            `json:"last_pruned"`
+	Pruned      int64            `json:"pruned"`
+	LastError   string           `json:"last_error,omitempty"`
+	LastErrorAt *time.Time       `json:"last_error_at,omitempty"`
+	Rooms       []RoomPruneStats `json:"rooms"`
+}
+
+// RoomPruneStats is the retention of a room as applied by the janitor, its
+// policy completed by the global one.
+type RoomPruneStats struct {
+	Room         string          `json:"room"`
+	Policy       RetentionPolicy `json:"policy"`
+	LegalHold    bool            `json:"legal_hold"`
+	Pruned       int64           `json:"pruned"`
+	LastPrunedAt *time.Time      `json:"last_pruned_at,omitempty"`
+}
@@ -3,27 +3,130 @@ package models
 import (
 	"chat-app/pkg/queue"
 	"sync"
-
-	"github.com/gorilla/websocket"
+	"time"
 )
 
+// UIRoom is a room as listed to clients: Users are connected right now,
+// LastActivity is when its latest message was posted.
 type UIRoom struct {
-	Room  string `json:"room"`
-	Users int    `json:"users"`
+	Room         string     `json:"room"`
+	Users        int        `json:"users"`
+	Messages     int64      `json:"messages"`
+	LastActivity *time.Time `json:"last_activity,omitempty"`
 }
 
 type Room struct {
-	ID         string
-	Connection []*websocket.Conn `json:"-"    gorm:"-"`
-	Worker     *queue.Worker     `json:"-"    gorm:"-"`
+	ID string
+	// Retention overrides the global retention policy where set; rooms under
+	// LegalHold are never pruned.
+	Retention  RetentionPolicy `gorm:"embedded;embeddedPrefix:retention_"`
+	LegalHold  bool
+	Connection []*Client     `json:"-"    gorm:"-"`
+	Worker     *queue.Worker `json:"-"    gorm:"-"`
 	mu         sync.Mutex
+	nicknames  map[string]*reservation
+	// binds counts the bind requests being served; the room is idle since
+	// idleSince when there are none.
+	binds     int
+	idleSince time.Time
+}
+
+// reservation holds a nickname for an owner across all of its connections.
+type reservation struct {
+	owner string
+	conns int
 }
 
-func NewRoom(roomID string) *Room {
+// WorkerName is the name of the worker of a room.
+func WorkerName(roomID string) string {
+	return "room:" + roomID
+}
+
+func NewRoom(roomID string, opts ...queue.WorkerOption) *Room {
 	return &Room{
 		ID:         roomID,
-		Worker:     queue.NewWorker(roomID),
-		Connection: []*websocket.Conn{},
+		Worker:     queue.NewWorker(WorkerName(roomID), opts...),
+		Connection: []*Client{},
 		mu:         sync.Mutex{},
+		nicknames:  map[string]*reservation{},
+		idleSince:  time.Now(),
+	}
+}
+
+// Bind marks the room as in use by a bind request until Unbind.
+func (r *Room) Bind() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.binds++
+}
+
+func (r *Room) Unbind() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.binds--
+	if r.binds == 0 {
+		r.idleSince = time.Now()
+	}
+}
+
+// IdleFor returns how long the room has had no bind request, or zero while
+// it has some.
+func (r *Room) IdleFor() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.binds > 0 {
+		return 0
 	}
+	return time.Since(r.idleSince)
+}
+
+// Reserve claims nickname in the room for owner until every connection that
+// reserved it calls Release. It reports false when another owner holds it.
+func (r *Room) Reserve(nickname, owner string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nicknames == nil {
+		r.nicknames = map[string]*reservation{}
+	}
+	res, found := r.nicknames[nickname]
+	if !found {
+		res = &reservation{owner: owner}
+		r.nicknames[nickname] = res
+	}
+	if res.owner != owner {
+		return false
+	}
+	res.conns++
+	return true
+}
+
+// Holds reports whether owner holds nickname in the room.
+func (r *Room) Holds(nickname, owner string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, found := r.nicknames[nickname]
+	return found && res.owner == owner
+}
+
+func (r *Room) Release(nickname, owner string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, found := r.nicknames[nickname]
+	if !found || res.owner != owner {
+		return
+	}
+	res.conns--
+	if res.conns <= 0 {
+		delete(r.nicknames, nickname)
+	}
+}
+
+// Clients returns a snapshot of the room connections safe to range over
+// while new clients keep binding.
+func (r *Room) Clients() []*Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := make([]*Client, len(r.Connection))
+	copy(clients, r.Connection)
+	return clients
 }
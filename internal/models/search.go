@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// SearchHit is a message matching a search. Snippet is an excerpt of its
+// content, HTML-escaped, with the matching terms wrapped in <mark> tags.
+type SearchHit struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	Nickname  string    `json:"nickname"`
+	Timestamp time.Time `json:"timestamp"`
+	Snippet   string    `json:"snippet"`
+}
+
+// SearchPage is a page of search hits, newest first; HasMore reports whether
+// older hits exist.
+type SearchPage struct {
+	Results []SearchHit `json:"results"`
+	HasMore bool        `json:"has_more"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// ImportResult reports how many messages of an import were stored, and how
+// many were skipped because an earlier import of the same export stored them.
+type ImportResult struct {
+	Room     string `json:"room"`
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"`
+}
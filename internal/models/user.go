@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+type User struct {
+	ID           string    `json:"id"          gorm:"primaryKey"`
+	Username     string    `json:"username"    gorm:"uniqueIndex"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type Credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Session ties a short-lived access token and a refresh token to the user
+// they authenticate; only the token hashes are stored. Refreshing rotates
+// both tokens, invalidating the previous pair.
+type Session struct {
+	ID               string `gorm:"primaryKey"`
+	TokenHash        string `gorm:"uniqueIndex"`
+	RefreshHash      string `gorm:"uniqueIndex"`
+	UserID           string `gorm:"index"`
+	Nickname         string
+	Guest            bool
+	ExpiresAt        time.Time
+	RefreshExpiresAt time.Time
+	CreatedAt        time.Time
+}
+
+type AuthToken struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	RefreshToken string    `json:"refresh_token"`
+	User         User      `json:"user"`
+	Guest        bool      `json:"guest,omitempty"`
+}
+
+// Owner identifies the holder of the session's nickname reservations.
+func (s Session) Owner() string {
+	if s.Guest {
+		return "guest:" + s.ID
+	}
+	return s.UserID
+}
+
+type GuestRequest struct {
+	Nickname string `json:"nickname" binding:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
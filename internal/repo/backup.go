@@ -0,0 +1,185 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrBackupUnsupported is returned by Backup and Restore on databases other
+// than SQLite, which are backed up with their own tools, such as pg_dump.
+var ErrBackupUnsupported = errors.New("backups are only supported on sqlite databases; use pg_dump for postgres")
+
+// Backuper takes online backups of a database.
+type Backuper interface {
+	// Backup writes a consistent snapshot of the database to path.
+	Backup(path string) error
+}
+
+var _ Backuper = (*Repo)(nil)
+
+// BackupFileName names the backup taken at t.
+func BackupFileName(t time.Time) string {
+	return "chat-" + t.UTC().Format("20060102T150405.000Z") + ".db"
+}
+
+// Backup writes a consistent snapshot of the database to path, which must
+// not exist, while it stays in use: VACUUM INTO copies it within a single
+// read transaction, which in WAL mode does not block the writers.
+func (r *Repo) Backup(path string) error {
+	if r.driver != DriverSQLite {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return r.DB.Exec("VACUUM INTO ?", path).Error
+}
+
+// RestoreResult describes a restore: the schema version of the backup, and
+// where the database it replaced was kept, if there was one.
+type RestoreResult struct {
+	SchemaVersion int
+	Previous      string
+}
+
+// Restore replaces the SQLite database at dsn with the backup at path. The
+// server must be stopped. The backup is copied next to the database and
+// checked first: it must pass an integrity check and be at a schema version
+// this build knows, older ones being migrated. Only then is it swapped in,
+// the replaced database being kept as {file}.pre-restore-{time} so the
+// restore itself can be undone.
+func Restore(dsn, path string) (RestoreResult, error) {
+	target, ok := sqlitePath(dsn)
+	if !ok {
+		return RestoreResult{}, errors.New("cannot restore into an in-memory database")
+	}
+	staged := target + ".restore"
+	removeDatabase(staged)
+	if err := copyFile(path, staged); err != nil {
+		return RestoreResult{}, err
+	}
+	version, err := prepareRestore(staged)
+	if err != nil {
+		removeDatabase(staged)
+		return RestoreResult{}, fmt.Errorf("invalid backup %s: %w", path, err)
+	}
+
+	result := RestoreResult{SchemaVersion: version}
+	if _, err := os.Stat(target); err == nil {
+		if err := checkpoint(target); err != nil {
+			removeDatabase(staged)
+			return RestoreResult{}, err
+		}
+		result.Previous = target + ".pre-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		if err := os.Rename(target, result.Previous); err != nil {
+			removeDatabase(staged)
+			return RestoreResult{}, err
+		}
+	}
+	removeDatabase(target)
+	if err := os.Rename(staged, target); err != nil {
+		return result, err
+	}
+	removeDatabase(staged)
+	return result, nil
+}
+
+// prepareRestore checks the staged copy of a backup and migrates it to the
+// latest schema, returning the schema version of the backup.
+func prepareRestore(staged string) (int, error) {
+	r, err := NewRepo(staged, WithMigrate(false))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	var integrity string
+	if err := r.DB.Raw("PRAGMA integrity_check").Scan(&integrity).Error; err != nil {
+		return 0, err
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", integrity)
+	}
+	if !r.DB.Migrator().HasTable(schemaMigration{}) {
+		return 0, errors.New("not a chat database: no schema_migrations table")
+	}
+	version, err := r.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	latest := 0
+	if len(r.migrations) > 0 {
+		latest = r.migrations[len(r.migrations)-1].Version
+	}
+	switch {
+	case version == 0:
+		return 0, errors.New("not a chat database: no migration applied")
+	case version > latest:
+		return 0, fmt.Errorf("schema version %d is newer than version %d of this build", version, latest)
+	}
+	if err := r.Migrate(); err != nil {
+		return 0, err
+	}
+	return version, r.DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error
+}
+
+// ErrDatabaseInUse is returned by Restore when another process, such as
+// the server, still has the database open.
+var ErrDatabaseInUse = errors.New("the database is in use; stop the server before restoring")
+
+// checkpoint moves the WAL of the database at path into the database file,
+// so the file holds every committed write before it is moved away. SQLite
+// deletes the WAL when its last connection closes, so a WAL left behind
+// means another process has the database open.
+func checkpoint(path string) error {
+	r, err := NewRepo(path, WithMigrate(false))
+	if err != nil {
+		return err
+	}
+	err = r.DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path + "-wal"); err == nil {
+		return ErrDatabaseInUse
+	}
+	return nil
+}
+
+// removeDatabase removes a SQLite database with its WAL and shared memory
+// files.
+func removeDatabase(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
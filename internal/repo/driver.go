@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Drivers supported by NewRepo.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// DefaultBusyTimeout is how long a SQLite write waits for the lock held by
+// another writer before failing.
+const DefaultBusyTimeout = 5 * time.Second
+
+type options struct {
+	driver      string
+	busyTimeout time.Duration
+	migrate     bool
+}
+
+type Option func(*options)
+
+// WithDriver selects the database driver, DriverSQLite by default.
+func WithDriver(driver string) Option {
+	return func(o *options) {
+		o.driver = driver
+	}
+}
+
+// WithBusyTimeout sets how long a SQLite write waits on a locked database,
+// DefaultBusyTimeout by default.
+func WithBusyTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = timeout
+	}
+}
+
+// WithMigrate sets whether NewRepo applies the pending migrations, as it
+// does by default; the migrate subcommand opens the database without.
+func WithMigrate(migrate bool) Option {
+	return func(o *options) {
+		o.migrate = migrate
+	}
+}
+
+func (o options) dialector(dsn string) (gorm.Dialector, error) {
+	switch o.driver {
+	case DriverSQLite:
+		return sqlite.Open(sqliteDSN(dsn, o.busyTimeout)), nil
+	case DriverPostgres:
+		return postgres.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", o.driver)
+	}
+}
+
+// sqliteDSN tunes a file database for concurrent writes: WAL lets readers
+// run alongside the writer, immediate transactions take the write lock
+// upfront instead of failing to upgrade a read lock, and the busy timeout
+// makes writers queue for the lock. Parameters already in dsn are kept, and
+// in-memory databases are left alone.
+func sqliteDSN(dsn string, busyTimeout time.Duration) string {
+	if isMemoryDSN(dsn) {
+		return dsn
+	}
+
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return dsn
+	}
+	defaults := map[string]string{
+		"_journal_mode": "WAL",
+		"_synchronous":  "NORMAL",
+		"_txlock":       "immediate",
+		"_busy_timeout": strconv.FormatInt(busyTimeout.Milliseconds(), 10),
+	}
+	for key, value := range defaults {
+		if !query.Has(key) {
+			query.Set(key, value)
+		}
+	}
+	return path + "?" + query.Encode()
+}
+
+func isMemoryDSN(dsn string) bool {
+	return dsn == ":memory:" || strings.Contains(dsn, "mode=memory")
+}
+
+// sqlitePath returns the file of a SQLite dsn, reporting false for
+// in-memory databases.
+func sqlitePath(dsn string) (string, bool) {
+	if isMemoryDSN(dsn) {
+		return "", false
+	}
+	path, _, _ := strings.Cut(dsn, "?")
+	return strings.TrimPrefix(path, "file:"), true
+}
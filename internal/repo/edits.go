@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"chat-app/internal/models"
+	"chat-app/pkg/utils"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetMessage returns a room message by ID.
+func (r *Repo) GetMessage(room, id string) (models.Message, bool, error) {
+	var msg models.Message
+	err := r.DB.Where("room = ? AND id = ?", room, id).Take(&msg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Message{}, false, nil
+	}
+	return msg, err == nil, err
+}
+
+// EditMessage replaces the content of a room message at the given time,
+// keeping the previous content as a revision, and reports false when the
+// message does not exist. The message row is locked for the edit so
+// concurrent edits each keep the content they replaced.
+func (r *Repo) EditMessage(room, id, content string, at time.Time) (models.Message, bool, error) {
+	var msg models.Message
+	found := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("room = ? AND id = ?", room, id).
+			Take(&msg).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		if msg.Content == content {
+			return nil
+		}
+
+		if err := tx.Create(&models.MessageRevision{
+			ID:        utils.NewID(),
+			MessageID: msg.ID,
+			Content:   msg.Content,
+			Timestamp: writtenAt(msg),
+		}).Error; err != nil {
+			return err
+		}
+		editedAt := at.UTC()
+		msg.Content = content
+		msg.EditedAt = &editedAt
+		return tx.Model(&models.Message{ID: msg.ID}).
+			Select("content", "edited_at").
+			Updates(&msg).Error
+	})
+	if err != nil || !found {
+		return models.Message{}, false, err
+	}
+	return msg, true, nil
+}
+
+// ListRevisions returns the previous contents of a message, oldest first.
+func (r *Repo) ListRevisions(messageID string) ([]models.MessageRevision, error) {
+	revisions := []models.MessageRevision{}
+	err := r.DB.Where("message_id = ?", messageID).Order("id ASC").Find(&revisions).Error
+	return revisions, err
+}
+
+// writtenAt returns when the current content of msg was written.
+func writtenAt(msg models.Message) time.Time {
+	if msg.EditedAt != nil {
+		return *msg.EditedAt
+	}
+	return msg.Timestamp
+}
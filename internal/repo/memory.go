@@ -0,0 +1,496 @@
+package repo
+
+import (
+	"chat-app/internal/models"
+	"chat-app/pkg/queue"
+	"chat-app/pkg/utils"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore is a Store kept in memory, for tests that do not need a
+// database. It reports the same errors as Repo, gorm.ErrDuplicatedKey
+// included.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	rooms     []string
+	retention map[string]models.RoomRetention
+	messages  map[string]models.Message
+	revisions map[string][]models.MessageRevision
+	users     map[string]models.User
+	sessions  map[string]models.Session
+	tasks     map[string]queue.Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		retention: map[string]models.RoomRetention{},
+		messages:  map[string]models.Message{},
+		revisions: map[string][]models.MessageRevision{},
+		users:     map[string]models.User{},
+		sessions:  map[string]models.Session{},
+		tasks:     map[string]queue.Record{},
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) AddRoom(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.rooms, name) {
+		s.rooms = append(s.rooms, name)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetRooms() ([]*models.Room, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rooms := make(models.Rooms, 0, len(s.rooms))
+	for _, name := range s.rooms {
+		retention := s.retention[name]
+		rooms = append(rooms, &models.Room{ID: name, Retention: retention.Retention, LegalHold: retention.LegalHold})
+	}
+	return rooms, nil
+}
+
+func (s *MemoryStore) GetRoom(name string) (*models.Room, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !slices.Contains(s.rooms, name) {
+		return nil, false, nil
+	}
+	retention := s.retention[name]
+	return &models.Room{ID: name, Retention: retention.Retention, LegalHold: retention.LegalHold}, true, nil
+}
+
+func (s *MemoryStore) SetRoomRetention(name string, retention models.RoomRetention) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.rooms, name) {
+		return false, nil
+	}
+	s.retention[name] = retention
+	return true, nil
+}
+
+func (s *MemoryStore) GetRoomStats() ([]models.UIRoom, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := make([]models.UIRoom, 0, len(s.rooms))
+	byRoom := map[string]int{}
+	for _, name := range s.rooms {
+		byRoom[name] = len(stats)
+		stats = append(stats, models.UIRoom{Room: name})
+	}
+
+	lastIDs := map[string]string{}
+	for _, msg := range s.messages {
+		i, found := byRoom[msg.Room]
+		if !found {
+			continue
+		}
+		stats[i].Messages++
+		if msg.ID > lastIDs[msg.Room] {
+			lastIDs[msg.Room] = msg.ID
+			timestamp := msg.Timestamp
+			stats[i].LastActivity = &timestamp
+		}
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) AddMessage(msg models.Message) (models.Message, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.ID == "" {
+		msg.ID = utils.NewID()
+	}
+	if msg.ClientKey != "" {
+		for _, existing := range s.messages {
+			if existing.Room == msg.Room && existing.Nickname == msg.Nickname && existing.ClientKey == msg.ClientKey {
+				return existing, false, nil
+			}
+		}
+	}
+	if _, found := s.messages[msg.ID]; found {
+		return models.Message{}, false, gorm.ErrDuplicatedKey
+	}
+	s.messages[msg.ID] = msg
+	return msg, true, nil
+}
+
+func (s *MemoryStore) GetMessages(room string) ([]models.Message, error) {
+	page, err := s.ListMessages(room, MessageQuery{})
+	return page.Messages, err
+}
+
+func (s *MemoryStore) ListMessages(room string, q MessageQuery) (models.MessagePage, error) {
+	limit := messageLimit(q.Limit)
+
+	s.mu.RLock()
+	msgs := models.Messages{}
+	for _, msg := range s.messages {
+		if msg.Room != room ||
+			(q.After != "" && msg.ID <= q.After) ||
+			(q.Before != "" && msg.ID >= q.Before) ||
+			(!q.Since.IsZero() && !msg.Timestamp.After(q.Since)) {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	s.mu.RUnlock()
+
+	forward := q.After != "" || !q.Since.IsZero()
+	slices.SortFunc(msgs, func(a, b models.Message) int { return strings.Compare(a.ID, b.ID) })
+	if !forward {
+		slices.Reverse(msgs)
+	}
+
+	page := models.MessagePage{HasMore: len(msgs) > limit}
+	if page.HasMore {
+		msgs = msgs[:limit]
+	}
+	if !forward {
+		slices.Reverse(msgs)
+	}
+	page.Messages = msgs
+	return page, nil
+}
+
+func (s *MemoryStore) GetMessage(room, id string) (models.Message, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	msg, found := s.messages[id]
+	if !found || msg.Room != room {
+		return models.Message{}, false, nil
+	}
+	return msg, true, nil
+}
+
+func (s *MemoryStore) EditMessage(room, id, content string, at time.Time) (models.Message, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, found := s.messages[id]
+	if !found || msg.Room != room {
+		return models.Message{}, false, nil
+	}
+	if msg.Content == content {
+		return msg, true, nil
+	}
+	s.revisions[id] = append(s.revisions[id], models.MessageRevision{
+		ID:        utils.NewID(),
+		MessageID: id,
+		Content:   msg.Content,
+		Timestamp: writtenAt(msg),
+	})
+	editedAt := at.UTC()
+	msg.Content = content
+	msg.EditedAt = &editedAt
+	s.messages[id] = msg
+	return msg, true, nil
+}
+
+func (s *MemoryStore) ListRevisions(messageID string) ([]models.MessageRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.MessageRevision{}, s.revisions[messageID]...), nil
+}
+
+func (s *MemoryStore) EachMessage(room string, fn func(models.Message) error) error {
+	s.mu.RLock()
+	msgs := models.Messages{}
+	for _, msg := range s.messages {
+		if msg.Room == room {
+			msgs = append(msgs, msg)
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(msgs, func(a, b models.Message) int { return strings.Compare(a.ID, b.ID) })
+	for _, msg := range msgs {
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) PruneMessages(room string, policy models.RetentionPolicy, now time.Time, limit int) (int64, error) {
+	if policy.IsZero() {
+		return 0, nil
+	}
+	if limit <= 0 {
+		limit = DefaultPruneBatch
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := models.Messages{}
+	for _, msg := range s.messages {
+		if msg.Room == room {
+			msgs = append(msgs, msg)
+		}
+	}
+	slices.SortFunc(msgs, func(a, b models.Message) int { return strings.Compare(a.ID, b.ID) })
+
+	// messages before keepFrom are past the count or size bounds
+	keepFrom := 0
+	if policy.MaxCount > 0 && int64(len(msgs)) > policy.MaxCount {
+		keepFrom = len(msgs) - int(policy.MaxCount)
+	}
+	if policy.MaxBytes > 0 {
+		total := int64(0)
+		i := len(msgs)
+		for i > 0 && total+int64(len(msgs[i-1].Content)) <= policy.MaxBytes {
+			total += int64(len(msgs[i-1].Content))
+			i--
+		}
+		keepFrom = max(keepFrom, i)
+	}
+
+	pruned := int64(0)
+	for i, msg := range msgs {
+		if pruned == int64(limit) {
+			break
+		}
+		if i < keepFrom || (policy.MaxAge > 0 && msg.Timestamp.Before(now.Add(-policy.MaxAge))) {
+			delete(s.messages, msg.ID)
+			delete(s.revisions, msg.ID)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// SearchMessages matches the terms as case-insensitive substrings.
+func (s *MemoryStore) SearchMessages(q SearchQuery) (models.SearchPage, error) {
+	page := models.SearchPage{Results: []models.SearchHit{}}
+	terms := searchTerms(q.Text)
+	if len(terms) == 0 {
+		return page, nil
+	}
+	limit := messageLimit(q.Limit)
+
+	s.mu.RLock()
+	msgs := models.Messages{}
+	for _, msg := range s.messages {
+		if (q.Room != "" && msg.Room != q.Room) ||
+			(q.Author != "" && msg.Nickname != q.Author) ||
+			(!q.From.IsZero() && msg.Timestamp.Before(q.From)) ||
+			(!q.To.IsZero() && !msg.Timestamp.Before(q.To)) ||
+			(q.Before != "" && msg.ID >= q.Before) ||
+			!containsAll(msg.Content, terms) {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(msgs, func(a, b models.Message) int { return strings.Compare(b.ID, a.ID) })
+	page.HasMore = len(msgs) > limit
+	if page.HasMore {
+		msgs = msgs[:limit]
+	}
+	for _, msg := range msgs {
+		page.Results = append(page.Results, models.SearchHit{
+			ID:        msg.ID,
+			Room:      msg.Room,
+			Nickname:  msg.Nickname,
+			Timestamp: msg.Timestamp,
+			Snippet:   markSnippet(highlight(msg.Content, terms)),
+		})
+	}
+	return page, nil
+}
+
+func containsAll(content string, terms []string) bool {
+	content = strings.ToLower(content)
+	for _, term := range terms {
+		if !strings.Contains(content, strings.ToLower(term)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) AddUser(user models.User) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.ID == "" {
+		user.ID = utils.NewID()
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return models.User{}, ErrUsernameTaken
+		}
+	}
+	if _, found := s.users[user.ID]; found {
+		return models.User{}, gorm.ErrDuplicatedKey
+	}
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *MemoryStore) GetUser(username string) (models.User, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, true, nil
+		}
+	}
+	return models.User{}, false, nil
+}
+
+func (s *MemoryStore) GetUserByID(id string) (models.User, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, found := s.users[id]
+	return user, found, nil
+}
+
+func (s *MemoryStore) AddSession(session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session.ID == "" {
+		session.ID = utils.NewID()
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	for _, existing := range s.sessions {
+		if existing.ID == session.ID || existing.TokenHash == session.TokenHash || existing.RefreshHash == session.RefreshHash {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *MemoryStore) GetSession(tokenHash string) (models.Session, bool, error) {
+	return s.findSession(func(session models.Session) bool { return session.TokenHash == tokenHash })
+}
+
+func (s *MemoryStore) GetSessionByRefresh(refreshHash string) (models.Session, bool, error) {
+	return s.findSession(func(session models.Session) bool { return session.RefreshHash == refreshHash })
+}
+
+func (s *MemoryStore) findSession(match func(models.Session) bool) (models.Session, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, session := range s.sessions {
+		if match(session) {
+			return session, true, nil
+		}
+	}
+	return models.Session{}, false, nil
+}
+
+func (s *MemoryStore) RotateSession(refreshHash string, next models.Session) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.RefreshHash != refreshHash {
+			continue
+		}
+		session.TokenHash = next.TokenHash
+		session.RefreshHash = next.RefreshHash
+		session.ExpiresAt = next.ExpiresAt
+		session.RefreshExpiresAt = next.RefreshExpiresAt
+		s.sessions[id] = session
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) DeleteSession(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SaveTask(record queue.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.tasks[record.ID]; found {
+		return gorm.ErrDuplicatedKey
+	}
+	now := time.Now()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = now
+	}
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = now
+	}
+	record.Payload = slices.Clone(record.Payload)
+	s.tasks[record.ID] = record
+	return nil
+}
+
+func (s *MemoryStore) UpdateTask(record queue.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, found := s.tasks[record.ID]
+	if !found {
+		return nil
+	}
+	stored.Status = record.Status
+	stored.Attempts = record.Attempts
+	stored.LastError = record.LastError
+	stored.UpdatedAt = time.Now()
+	s.tasks[record.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteTask(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tasks, id)
+	return nil
+}
+
+func (s *MemoryStore) PendingTasks(worker string) ([]queue.Record, error) {
+	return s.listTasks(func(record queue.Record) bool {
+		return record.Worker == worker && record.Status == queue.TaskPending
+	})
+}
+
+func (s *MemoryStore) ListTasks(status queue.TaskStatus) ([]queue.Record, error) {
+	return s.listTasks(func(record queue.Record) bool { return record.Status == status })
+}
+
+// listTasks returns the matching tasks, oldest first like Repo does.
+func (s *MemoryStore) listTasks(match func(queue.Record) bool) ([]queue.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := []queue.Record{}
+	for _, record := range s.tasks {
+		if match(record) {
+			record.Payload = slices.Clone(record.Payload)
+			records = append(records, record)
+		}
+	}
+	slices.SortFunc(records, func(a, b queue.Record) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return records, nil
+}
@@ -0,0 +1,208 @@
+package repo
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a schema change shipped as a pair of up and down scripts in
+// migrations/{driver}, named {version}_{name}.up.sql and .down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a known migration was applied, and when.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// schemaMigration is a row of schema_migrations, one per applied migration.
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrations returns the migrations of driver, in version order.
+func Migrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, found := byVersion[version]
+		if !found {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// Migrate applies the pending migrations.
+func (r *Repo) Migrate() error {
+	if len(r.migrations) == 0 {
+		return nil
+	}
+	return r.MigrateTo(r.migrations[len(r.migrations)-1].Version)
+}
+
+// MigrateTo applies or reverts migrations until the schema is at version;
+// 0 reverts them all. Each migration runs in its own transaction together
+// with its schema_migrations row.
+func (r *Repo) MigrateTo(version int) error {
+	if version != 0 && !slices.ContainsFunc(r.migrations, func(m Migration) bool { return m.Version == version }) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	applied, err := r.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range r.migrations {
+		if _, done := applied[m.Version]; done || m.Version > version {
+			continue
+		}
+		err := r.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("applying migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		m := r.migrations[i]
+		if _, done := applied[m.Version]; !done || m.Version <= version {
+			continue
+		}
+		err := r.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: m.Version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return r.syncSearchIndex()
+}
+
+// Rollback reverts the last steps applied migrations.
+func (r *Repo) Rollback(steps int) error {
+	version, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(r.migrations, func(m Migration) bool { return m.Version == version })
+	if i-steps < 0 {
+		return r.MigrateTo(0)
+	}
+	return r.MigrateTo(r.migrations[i-steps].Version)
+}
+
+// SchemaVersion returns the version of the latest applied migration, or 0.
+func (r *Repo) SchemaVersion() (int, error) {
+	applied, err := r.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// MigrationStatus lists the known migrations with when they were applied.
+func (r *Repo) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := r.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(r.migrations))
+	for _, m := range r.migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, done := applied[m.Version]; done {
+			s.AppliedAt = &row.AppliedAt
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+func (r *Repo) appliedMigrations() (map[int]schemaMigration, error) {
+	if err := r.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp NOT NULL
+	)`).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+	if err := r.DB.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
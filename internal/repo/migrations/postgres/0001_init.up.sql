@@ -0,0 +1,52 @@
+-- The schema previously created by gorm's AutoMigrate; IF NOT EXISTS lets
+-- databases created that way adopt the migrations.
+CREATE TABLE IF NOT EXISTS rooms (
+    id text PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id text PRIMARY KEY,
+    room text,
+    nickname text,
+    "timestamp" timestamptz,
+    content text,
+    client_key text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_key ON messages (room, nickname, client_key) WHERE client_key <> '';
+CREATE INDEX IF NOT EXISTS idx_messages_room_id ON messages (room, id);
+
+CREATE TABLE IF NOT EXISTS users (
+    id text PRIMARY KEY,
+    username text,
+    password_hash text,
+    created_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id text PRIMARY KEY,
+    token_hash text,
+    refresh_hash text,
+    user_id text,
+    nickname text,
+    guest boolean,
+    expires_at timestamptz,
+    refresh_expires_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_hash ON sessions (refresh_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_token_hash ON sessions (token_hash);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id text PRIMARY KEY,
+    worker text,
+    kind text,
+    payload bytea,
+    status text,
+    attempts bigint,
+    last_error text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_worker_status ON tasks (worker, status);
@@ -0,0 +1,5 @@
+ALTER TABLE rooms
+    DROP COLUMN IF EXISTS legal_hold,
+    DROP COLUMN IF EXISTS retention_max_bytes,
+    DROP COLUMN IF EXISTS retention_max_count,
+    DROP COLUMN IF EXISTS retention_max_age;
@@ -0,0 +1,5 @@
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS retention_max_age bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS retention_max_count bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS retention_max_bytes bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS legal_hold boolean NOT NULL DEFAULT false;
@@ -0,0 +1,10 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at timestamptz;
+
+-- The contents a message had before each of its edits.
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id text PRIMARY KEY,
+    message_id text NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    content text NOT NULL,
+    "timestamp" timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_message_revisions_message_id ON message_revisions (message_id, id);
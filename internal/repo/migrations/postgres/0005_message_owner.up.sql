@@ -0,0 +1,3 @@
+-- Who posted a message: a user ID, or the session of a guest. Messages
+-- posted before it was recorded have none, so nobody can edit them.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS owner text NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `tasks`;
+DROP TABLE IF EXISTS `sessions`;
+DROP TABLE IF EXISTS `users`;
+DROP TABLE IF EXISTS `messages`;
+DROP TABLE IF EXISTS `rooms`;
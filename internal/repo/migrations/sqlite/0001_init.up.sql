@@ -0,0 +1,57 @@
+-- The schema previously created by gorm's AutoMigrate; IF NOT EXISTS lets
+-- databases created that way adopt the migrations.
+CREATE TABLE IF NOT EXISTS `rooms` (
+    `id` text,
+    PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `messages` (
+    `id` text,
+    `room` text,
+    `nickname` text,
+    `timestamp` datetime,
+    `content` text,
+    `client_key` text,
+    PRIMARY KEY (`id`)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_messages_client_key` ON `messages` (`room`, `nickname`, `client_key`) WHERE client_key <> '';
+CREATE INDEX IF NOT EXISTS `idx_messages_room_id` ON `messages` (`room`, `id`);
+
+CREATE TABLE IF NOT EXISTS `users` (
+    `id` text,
+    `username` text,
+    `password_hash` text,
+    `created_at` datetime,
+    PRIMARY KEY (`id`)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_username` ON `users` (`username`);
+
+CREATE TABLE IF NOT EXISTS `sessions` (
+    `id` text,
+    `token_hash` text,
+    `refresh_hash` text,
+    `user_id` text,
+    `nickname` text,
+    `guest` numeric,
+    `expires_at` datetime,
+    `refresh_expires_at` datetime,
+    `created_at` datetime,
+    PRIMARY KEY (`id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_sessions_user_id` ON `sessions` (`user_id`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_sessions_refresh_hash` ON `sessions` (`refresh_hash`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_sessions_token_hash` ON `sessions` (`token_hash`);
+
+CREATE TABLE IF NOT EXISTS `tasks` (
+    `id` text,
+    `worker` text,
+    `kind` text,
+    `payload` blob,
+    `status` text,
+    `attempts` integer,
+    `last_error` text,
+    `created_at` datetime,
+    `updated_at` datetime,
+    PRIMARY KEY (`id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_tasks_worker_status` ON `tasks` (`worker`, `status`);
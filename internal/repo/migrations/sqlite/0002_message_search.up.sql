@@ -0,0 +1,4 @@
+-- SQLite keeps its FTS5 search index outside the migrations since FTS5
+-- depends on the build; see Repo.syncSearchIndex. This keeps the versions
+-- of both drivers aligned.
+SELECT 1;
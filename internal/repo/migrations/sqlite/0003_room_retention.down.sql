@@ -0,0 +1,4 @@
+ALTER TABLE `rooms` DROP COLUMN `legal_hold`;
+ALTER TABLE `rooms` DROP COLUMN `retention_max_bytes`;
+ALTER TABLE `rooms` DROP COLUMN `retention_max_count`;
+ALTER TABLE `rooms` DROP COLUMN `retention_max_age`;
@@ -0,0 +1,4 @@
+ALTER TABLE `rooms` ADD COLUMN `retention_max_age` integer NOT NULL DEFAULT 0;
+ALTER TABLE `rooms` ADD COLUMN `retention_max_count` integer NOT NULL DEFAULT 0;
+ALTER TABLE `rooms` ADD COLUMN `retention_max_bytes` integer NOT NULL DEFAULT 0;
+ALTER TABLE `rooms` ADD COLUMN `legal_hold` numeric NOT NULL DEFAULT false;
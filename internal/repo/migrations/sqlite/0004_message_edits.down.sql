@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS `message_revisions_delete`;
+DROP TABLE IF EXISTS `message_revisions`;
+ALTER TABLE `messages` DROP COLUMN `edited_at`;
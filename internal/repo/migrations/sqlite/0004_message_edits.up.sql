@@ -0,0 +1,17 @@
+ALTER TABLE `messages` ADD COLUMN `edited_at` datetime;
+
+-- The contents a message had before each of its edits.
+CREATE TABLE `message_revisions` (
+    `id` text,
+    `message_id` text NOT NULL,
+    `content` text NOT NULL,
+    `timestamp` datetime NOT NULL,
+    PRIMARY KEY (`id`)
+);
+CREATE INDEX `idx_message_revisions_message_id` ON `message_revisions` (`message_id`, `id`);
+
+-- Foreign keys are not enforced on SQLite connections by default, so the
+-- revisions of pruned messages are deleted by a trigger.
+CREATE TRIGGER `message_revisions_delete` AFTER DELETE ON `messages` BEGIN
+    DELETE FROM `message_revisions` WHERE `message_id` = old.`id`;
+END;
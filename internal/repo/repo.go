@@ -2,48 +2,281 @@ package repo
 
 import (
 	"chat-app/internal/models"
+	"chat-app/pkg/utils"
+	"errors"
+	"slices"
+	"time"
 
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repo struct {
 	DB *gorm.DB
+
+	driver     string
+	migrations []Migration
+	// fts5 is set when SearchMessages can use the SQLite FTS5 index.
+	fts5 bool
 }
 
-func NewRepo(sqliteDB string) (*Repo, error) {
-	db, err := gorm.Open(sqlite.Open(sqliteDB), &gorm.Config{})
+// NewRepo opens the database at dsn, a SQLite file or ":memory:", or a
+// Postgres connection string WithDriver(DriverPostgres), and applies the
+// pending migrations.
+func NewRepo(dsn string, opts ...Option) (*Repo, error) {
+	o := options{driver: DriverSQLite, busyTimeout: DefaultBusyTimeout, migrate: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	dialector, err := o.dialector(dsn)
 	if err != nil {
 		return nil, err
 	}
-
-	err = db.AutoMigrate(
-		&models.Room{},
-		&models.Message{},
-	)
+	migrations, err := Migrations(o.driver)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
+	if o.driver == DriverSQLite && isMemoryDSN(dsn) {
+		// every connection to an in-memory database opens a new, empty one,
+		// so the pool is kept to the single connection holding the data
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	r := &Repo{DB: db, driver: o.driver, migrations: migrations}
+	r.fts5 = o.driver == DriverSQLite && hasFTS5(db)
+	if o.migrate {
+		if err := r.Migrate(); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
 
-	return &Repo{DB: db}, nil
+// Close closes the database once the pending writes are done.
+func (r *Repo) Close() error {
+	db, err := r.DB.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
 }
 
+// AddRoom stores a room unless it already exists, as when a room evicted
+// from memory is bound again.
 func (r *Repo) AddRoom(name string) error {
-	return r.DB.Create(&models.Room{ID: name}).Error
+	return r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Room{ID: name}).Error
 }
 
-func (r *Repo) GetRooms() ([]models.Room, error) {
+func (r *Repo) GetRooms() ([]*models.Room, error) {
 	var rooms models.Rooms
 	err := r.DB.Find(&rooms).Error
 	return rooms, err
 }
 
-func (r *Repo) AddMessage(msg models.Message) error {
-	return r.DB.Create(&msg).Error
+func (r *Repo) GetRoom(name string) (*models.Room, bool, error) {
+	var room models.Room
+	err := r.DB.Where("id = ?", name).Take(&room).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &room, true, nil
+}
+
+// GetRoomStats returns every stored room with its message count and the
+// time of its latest message, leaving Users to the caller.
+func (r *Repo) GetRoomStats() ([]models.UIRoom, error) {
+	rooms, err := r.GetRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		Room     string
+		Messages int64
+		LastID   string
+	}
+	err = r.DB.Model(&models.Message{}).
+		Select("room, COUNT(*) AS messages, MAX(id) AS last_id").
+		Group("room").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	lastIDs := make([]string, 0, len(counts))
+	for _, count := range counts {
+		lastIDs = append(lastIDs, count.LastID)
+	}
+	var last models.Messages
+	if len(lastIDs) > 0 {
+		if err = r.DB.Where("id IN ?", lastIDs).Find(&last).Error; err != nil {
+			return nil, err
+		}
+	}
+	lastByID := models.ToMap(last)
+
+	stats := make([]models.UIRoom, 0, len(rooms))
+	byRoom := map[string]int{}
+	for _, room := range rooms {
+		byRoom[room.ID] = len(stats)
+		stats = append(stats, models.UIRoom{Room: room.ID})
+	}
+	for _, count := range counts {
+		i, found := byRoom[count.Room]
+		if !found {
+			continue
+		}
+		stats[i].Messages = count.Messages
+		if msg, found := lastByID[count.LastID]; found {
+			timestamp := msg.Timestamp
+			stats[i].LastActivity = &timestamp
+		}
+	}
+	return stats, nil
+}
+
+// AddMessage stores msg and reports whether it was created. A message whose
+// ClientKey was already used by the same nickname in the room is not stored
+// again; the original message is returned instead.
+func (r *Repo) AddMessage(msg models.Message) (models.Message, bool, error) {
+	if msg.ID == "" {
+		msg.ID = utils.NewID()
+	}
+	if msg.ClientKey != "" {
+		existing, found, err := r.getMessageByClientKey(msg)
+		if err != nil || found {
+			return existing, false, err
+		}
+	}
+
+	err := r.DB.Create(&msg).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) && msg.ClientKey != "" {
+		existing, found, err := r.getMessageByClientKey(msg)
+		if err != nil || found {
+			return existing, false, err
+		}
+	}
+	if err != nil {
+		return models.Message{}, false, err
+	}
+	return msg, true, nil
+}
+
+func (r *Repo) getMessageByClientKey(msg models.Message) (models.Message, bool, error) {
+	// Find rather than Take, which would log every key seen for the first
+	// time, as for each message of an import, as a missing record.
+	var existing models.Message
+	res := r.DB.
+		Where("room = ? AND nickname = ? AND client_key = ?", msg.Room, msg.Nickname, msg.ClientKey).
+		Limit(1).
+		Find(&existing)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return models.Message{}, false, res.Error
+	}
+	return existing, true, nil
+}
+
+const (
+	DefaultMessageLimit = 50
+	MaxMessageLimit     = 200
+)
+
+// MessageQuery selects a window of room messages by ID cursors. Before pages
+// back from the newest messages, After pages forward from the given ID; with
+// neither the latest messages are returned. A non-zero Since pages forward
+// from that timestamp like After does.
+type MessageQuery struct {
+	Before string
+	After  string
+	Since  time.Time
+	Limit  int
+}
+
+// messageLimit bounds a requested page size, DefaultMessageLimit when unset.
+func messageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultMessageLimit
+	}
+	return min(limit, MaxMessageLimit)
 }
 
+// GetMessages returns the latest room messages.
 func (r *Repo) GetMessages(room string) ([]models.Message, error) {
-	var msgs []models.Message
-	err := r.DB.Find(&msgs, "room = ?", room).Limit(50).Order("timestamp ASC").Error
-	return msgs, err
+	page, err := r.ListMessages(room, MessageQuery{})
+	return page.Messages, err
+}
+
+func (r *Repo) ListMessages(room string, q MessageQuery) (models.MessagePage, error) {
+	limit := messageLimit(q.Limit)
+
+	tx := r.DB.Where("room = ?", room)
+	if q.After != "" {
+		tx = tx.Where("id > ?", q.After)
+	}
+	if q.Before != "" {
+		tx = tx.Where("id < ?", q.Before)
+	}
+	if !q.Since.IsZero() {
+		tx = tx.Where(clause.Gt{Column: clause.Column{Name: "timestamp"}, Value: q.Since.UTC()})
+	}
+	forward := q.After != "" || !q.Since.IsZero()
+	if forward {
+		tx = tx.Order("id ASC")
+	} else {
+		tx = tx.Order("id DESC")
+	}
+
+	msgs := models.Messages{}
+	if err := tx.Limit(limit + 1).Find(&msgs).Error; err != nil {
+		return models.MessagePage{}, err
+	}
+
+	page := models.MessagePage{HasMore: len(msgs) > limit}
+	if page.HasMore {
+		msgs = msgs[:limit]
+	}
+	if !forward {
+		slices.Reverse(msgs)
+	}
+	page.Messages = msgs
+	return page, nil
+}
+
+// DefaultExportBatch is how many messages EachMessage loads per query.
+const DefaultExportBatch = 500
+
+// EachMessage calls fn with every room message in ascending ID order,
+// loading them DefaultExportBatch at a time after the last ID seen.
+func (r *Repo) EachMessage(room string, fn func(models.Message) error) error {
+	after := ""
+	for {
+		var msgs models.Messages
+		err := r.DB.Where("room = ? AND id > ?", room, after).
+			Order("id ASC").
+			Limit(DefaultExportBatch).
+			Find(&msgs).Error
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+		if len(msgs) < DefaultExportBatch {
+			return nil
+		}
+		after = msgs[len(msgs)-1].ID
+	}
 }
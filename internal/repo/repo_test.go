@@ -2,55 +2,286 @@ package repo
 
 import (
 	"chat-app/internal/models"
+	"chat-app/pkg/queue"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/stretchr/testify/suite"
+	"github.com/stretchr/testify/require"
 )
 
 var (
 	testRoom = "testroom"
 )
 
-type RepoTestSuite struct {
-	suite.Suite
-	repo *Repo
+func TestSQLiteFileConcurrentWrites(t *testing.T) {
+	repo, err := NewRepo(filepath.Join(t.TempDir(), "chat.db"), WithBusyTimeout(10*time.Second))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	var journalMode string
+	require.NoError(t, repo.DB.Raw("PRAGMA journal_mode").Scan(&journalMode).Error)
+	require.Equal(t, "wal", journalMode)
+	var busyTimeout int
+	require.NoError(t, repo.DB.Raw("PRAGMA busy_timeout").Scan(&busyTimeout).Error)
+	require.Equal(t, 10000, busyTimeout)
+
+	const writers, perWriter = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				_, _, err := repo.AddMessage(models.Message{
+					Room:      testRoom,
+					Nickname:  fmt.Sprintf("user%d", w),
+					Content:   fmt.Sprintf("message %d", i),
+					Timestamp: time.Now(),
+				})
+				errs <- err
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	var count int64
+	require.NoError(t, repo.DB.Model(&models.Message{}).Count(&count).Error)
+	require.Equal(t, int64(writers*perWriter), count)
 }
 
-func (suite *RepoTestSuite) SetupSuite() {
-	var err error
-	suite.repo, err = NewRepo(":memory:")
-	if err != nil {
-		suite.T().Fatal(err)
+func TestSQLiteMemoryPool(t *testing.T) {
+	repo, err := NewRepo(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+	require.NoError(t, repo.AddRoom(testRoom))
+
+	// a transaction holds a connection while another query needs one
+	tx := repo.DB.Begin()
+	require.NoError(t, tx.Error)
+	read := make(chan error, 1)
+	go func() {
+		_, err := repo.GetMessages(testRoom)
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, tx.Commit().Error)
+	require.NoError(t, <-read, "queries share the connection holding the in-memory database")
+}
+
+func TestMigrations(t *testing.T) {
+	for _, driver := range []string{DriverSQLite, DriverPostgres} {
+		migrations, err := Migrations(driver)
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations)
+		for i, m := range migrations {
+			require.Equal(t, i+1, m.Version, "%s migrations are numbered from 1 without gaps", driver)
+		}
 	}
+
+	repo, err := NewRepo(filepath.Join(t.TempDir(), "chat.db"), WithMigrate(false))
+	require.NoError(t, err)
+	defer repo.Close()
+	version, err := repo.SchemaVersion()
+	require.NoError(t, err)
+	require.Zero(t, version)
+	require.False(t, repo.DB.Migrator().HasTable("messages"))
+
+	require.NoError(t, repo.Migrate())
+	require.NoError(t, repo.Migrate(), "migrating an up to date schema is a no-op")
+	status, err := repo.MigrationStatus()
+	require.NoError(t, err)
+	for _, s := range status {
+		require.NotNil(t, s.AppliedAt, "migration %d_%s", s.Version, s.Name)
+	}
+	require.NoError(t, repo.AddRoom(testRoom))
+
+	require.NoError(t, repo.Rollback(len(status)))
+	version, err = repo.SchemaVersion()
+	require.NoError(t, err)
+	require.Zero(t, version)
+	require.False(t, repo.DB.Migrator().HasTable("rooms"))
+	require.Error(t, repo.MigrateTo(len(status)+1))
+}
+
+// legacyRoom is models.Room as it was when AutoMigrate created the schema.
+type legacyRoom struct {
+	ID string `gorm:"primaryKey"`
+}
+
+func (legacyRoom) TableName() string {
+	return "rooms"
+}
+
+// legacyMessage is models.Message as it was when AutoMigrate created the
+// schema.
+type legacyMessage struct {
+	ID        string `gorm:"primaryKey;index:idx_messages_room_id,priority:2"`
+	Room      string `gorm:"index:idx_messages_room_id,priority:1;uniqueIndex:idx_messages_client_key,where:client_key <> ''"`
+	Nickname  string `gorm:"uniqueIndex:idx_messages_client_key"`
+	Timestamp time.Time
+	Content   string
+	ClientKey string `gorm:"uniqueIndex:idx_messages_client_key"`
+}
+
+func (legacyMessage) TableName() string {
+	return "messages"
 }
 
-func (suite *RepoTestSuite) Test1Room() {
-	err := suite.repo.AddRoom(testRoom)
-	suite.NoError(err)
+func TestMigrateAutoMigratedDatabase(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "chat.db")
+	legacy, err := NewRepo(dsn, WithMigrate(false))
+	require.NoError(t, err)
+	require.NoError(t, legacy.DB.AutoMigrate(&legacyRoom{}, &legacyMessage{}, &models.User{}, &models.Session{}, &queue.Record{}))
+	require.NoError(t, legacy.DB.Create(&legacyRoom{ID: testRoom}).Error)
+	require.NoError(t, legacy.Close())
+
+	repo, err := NewRepo(dsn)
+	require.NoError(t, err, "the first migration adopts the tables created by AutoMigrate")
+	defer repo.Close()
+	rooms, err := repo.GetRooms()
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+}
 
-	rooms, err := suite.repo.GetRooms()
-	roomsMap := models.ToMap(rooms)
-	suite.NoError(err)
-	testRoom, found := roomsMap[testRoom]
-	suite.True(found)
-	suite.Equal(testRoom.ID, testRoom.ID)
+func TestUnsupportedDriver(t *testing.T) {
+	_, err := NewRepo("whatever", WithDriver("oracle"))
+	require.ErrorContains(t, err, "unsupported database driver")
 }
 
-func (suite *RepoTestSuite) Test2Message() {
-	msg := models.Message{
-		Room:     testRoom,
-		Nickname: "user1",
-		Content:  "Hello, world!",
+func TestSearchIndexRebuild(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "chat.db")
+	repo, err := NewRepo(dsn)
+	require.NoError(t, err)
+	if !repo.fts5 {
+		repo.Close()
+		t.Skip("SQLite built without the sqlite_fts5 tag")
 	}
-	err := suite.repo.AddMessage(msg)
-	suite.NoError(err)
+	_, _, err = repo.AddMessage(models.Message{Room: testRoom, Nickname: "user1", Content: "indexed by the triggers"})
+	require.NoError(t, err)
 
-	messages, err := suite.repo.GetMessages(testRoom)
-	suite.NoError(err)
-	suite.Len(messages, 1)
-	suite.Equal(msg.Content, messages[0].Content)
+	// a build without FTS5 drops the triggers, leaving later messages out
+	repo.fts5 = false
+	require.NoError(t, repo.syncSearchIndex())
+	_, _, err = repo.AddMessage(models.Message{Room: testRoom, Nickname: "user1", Content: "written without the index"})
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	repo, err = NewRepo(dsn)
+	require.NoError(t, err)
+	defer repo.Close()
+	page, err := repo.SearchMessages(SearchQuery{Text: "index"})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 2, "the index is rebuilt with the triggers")
+	require.Equal(t, "written without the <mark>index</mark>", page.Results[0].Snippet)
 }
 
-func TestRepoTestSuite(t *testing.T) {
-	suite.Run(t, new(RepoTestSuite))
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	dsn := filepath.Join(dir, "chat.db")
+	repo, err := NewRepo(dsn)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddRoom(testRoom))
+	_, _, err = repo.AddMessage(models.Message{Room: testRoom, Nickname: "user1", Content: "kept by the backup"})
+	require.NoError(t, err)
+
+	backup := filepath.Join(dir, BackupFileName(time.Now()))
+	require.NoError(t, repo.Backup(backup), "backups are taken while the database is open")
+	require.ErrorContains(t, repo.Backup(backup), "already exists")
+	_, _, err = repo.AddMessage(models.Message{Room: testRoom, Nickname: "user1", Content: "lost by the restore"})
+	require.NoError(t, err)
+
+	_, err = Restore(dsn, backup)
+	require.ErrorIs(t, err, ErrDatabaseInUse)
+	require.NoError(t, repo.Close())
+
+	result, err := Restore(dsn, backup)
+	require.NoError(t, err)
+	require.Equal(t, len(repo.migrations), result.SchemaVersion)
+	require.FileExists(t, result.Previous)
+
+	restored, err := NewRepo(dsn)
+	require.NoError(t, err)
+	msgs, err := restored.GetMessages(testRoom)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "kept by the backup", msgs[0].Content)
+	page, err := restored.SearchMessages(SearchQuery{Text: "backup"})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1, "the search index is restored with the messages")
+	require.NoError(t, restored.Close())
+
+	previous, err := NewRepo(result.Previous)
+	require.NoError(t, err)
+	msgs, err = previous.GetMessages(testRoom)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2, "the replaced database is kept whole")
+	require.NoError(t, previous.Close())
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	dir := t.TempDir()
+	dsn := filepath.Join(dir, "chat.db")
+	repo, err := NewRepo(dsn)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddRoom(testRoom))
+	require.NoError(t, repo.Close())
+
+	notSQLite := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(notSQLite, []byte("not a database, but long enough to look like a header"), 0o600))
+	_, err = Restore(dsn, notSQLite)
+	require.ErrorContains(t, err, "invalid backup")
+
+	unversioned := filepath.Join(dir, "unversioned.db")
+	other, err := NewRepo(unversioned, WithMigrate(false))
+	require.NoError(t, err)
+	require.NoError(t, other.DB.Exec("CREATE TABLE notes (id INTEGER)").Error)
+	require.NoError(t, other.Close())
+	_, err = Restore(dsn, unversioned)
+	require.ErrorContains(t, err, "not a chat database")
+
+	newer := filepath.Join(dir, "newer.db")
+	other, err = NewRepo(newer)
+	require.NoError(t, err)
+	require.NoError(t, other.DB.Create(&schemaMigration{Version: 9999, Name: "from_the_future", AppliedAt: time.Now()}).Error)
+	require.NoError(t, other.Close())
+	_, err = Restore(dsn, newer)
+	require.ErrorContains(t, err, "schema version 9999 is newer")
+
+	repo, err = NewRepo(dsn)
+	require.NoError(t, err)
+	rooms, err := repo.GetRooms()
+	require.NoError(t, err)
+	require.Len(t, rooms, 1, "invalid backups leave the database alone")
+	require.NoError(t, repo.Close())
+
+	older := filepath.Join(dir, "older.db")
+	other, err = NewRepo(older)
+	require.NoError(t, err)
+	require.NoError(t, other.MigrateTo(1))
+	require.NoError(t, other.Close())
+	result, err := Restore(dsn, older)
+	require.NoError(t, err, "older schemas are migrated")
+	require.Equal(t, 1, result.SchemaVersion)
+
+	restored, err := NewRepo(dsn, WithMigrate(false))
+	require.NoError(t, err)
+	defer restored.Close()
+	version, err := restored.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, len(restored.migrations), version)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		require.NotContains(t, entry.Name(), ".restore", "staged copies are cleaned up")
+	}
 }
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"chat-app/internal/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultPruneBatch is how many messages PruneMessages deletes at most per
+// call, keeping each delete transaction short.
+const DefaultPruneBatch = 500
+
+// SetRoomRetention stores the retention of a room, reporting false when the
+// room does not exist.
+func (r *Repo) SetRoomRetention(name string, retention models.RoomRetention) (bool, error) {
+	res := r.DB.Model(&models.Room{ID: name}).
+		Select("retention_max_age", "retention_max_count", "retention_max_bytes", "legal_hold").
+		Updates(&models.Room{Retention: retention.Retention, LegalHold: retention.LegalHold})
+	return res.RowsAffected == 1, res.Error
+}
+
+// PruneMessages deletes up to limit of the oldest room messages that policy
+// expires at now, returning how many were deleted; fewer than limit means
+// the room is within the policy.
+func (r *Repo) PruneMessages(room string, policy models.RetentionPolicy, now time.Time, limit int) (int64, error) {
+	if policy.IsZero() {
+		return 0, nil
+	}
+	if limit <= 0 {
+		limit = DefaultPruneBatch
+	}
+
+	expired := []clause.Expression{}
+	if policy.MaxAge > 0 {
+		expired = append(expired, clause.Lt{Column: clause.Column{Name: "timestamp"}, Value: now.Add(-policy.MaxAge).UTC()})
+	}
+	keepFrom, all, err := r.retainedFrom(room, policy)
+	if err != nil {
+		return 0, err
+	}
+	if all {
+		expired = append(expired, clause.Expr{SQL: "1 = 1"})
+	} else if keepFrom != "" {
+		expired = append(expired, clause.Lt{Column: clause.Column{Name: "id"}, Value: keepFrom})
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	oldest := r.DB.Model(&models.Message{}).
+		Select("id").
+		Where("room = ?", room).
+		Where(clause.Or(expired...)).
+		Order("id").
+		Limit(limit)
+	res := r.DB.Where("id IN (?)", oldest).Delete(&models.Message{})
+	return res.RowsAffected, res.Error
+}
+
+// retainedFrom returns the ID of the oldest room message kept by the count
+// and size bounds of policy, "" when they keep every message, or all when
+// they keep none.
+func (r *Repo) retainedFrom(room string, policy models.RetentionPolicy) (string, bool, error) {
+	keepFrom := ""
+	if policy.MaxCount > 0 {
+		var ids []string
+		err := r.DB.Model(&models.Message{}).
+			Where("room = ?", room).
+			Order("id DESC").
+			Offset(int(policy.MaxCount-1)).
+			Limit(1).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return "", false, err
+		}
+		if len(ids) == 1 {
+			keepFrom = ids[0]
+		}
+	}
+
+	if policy.MaxBytes > 0 {
+		size := "LENGTH(CAST(content AS BLOB))"
+		if r.driver == DriverPostgres {
+			size = "OCTET_LENGTH(content)"
+		}
+		totals := r.DB.Model(&models.Message{}).
+			Select("id, SUM("+size+") OVER (ORDER BY id DESC) AS total").
+			Where("room = ?", room)
+		var ids []string
+		err := r.DB.Table("(?) AS totals", totals).
+			Where("total <= ?", policy.MaxBytes).
+			Order("id").
+			Limit(1).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return "", false, err
+		}
+		if len(ids) == 0 {
+			var newest models.Message
+			err := r.DB.Where("room = ?", room).Select("id").Take(&newest).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", false, nil
+			}
+			// even the newest message is over the bound
+			return "", err == nil, err
+		}
+		keepFrom = max(keepFrom, ids[0])
+	}
+	return keepFrom, false, nil
+}
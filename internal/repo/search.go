@@ -0,0 +1,198 @@
+package repo
+
+import (
+	"chat-app/internal/models"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// SearchQuery selects the messages containing every term of Text, optionally
+// in one Room, by one Author and posted in [From, To). Hits come newest
+// first; Before pages back from the given message ID.
+type SearchQuery struct {
+	Text   string
+	Room   string
+	Author string
+	From   time.Time
+	To     time.Time
+	Before string
+	Limit  int
+}
+
+// Snippet markers wrap the matching terms until markSnippet turns them into
+// <mark> tags, once the rest of the snippet is HTML-escaped.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+	// snippetRunes is the length of the snippets cut out of long messages.
+	snippetRunes = 160
+)
+
+// SearchMessages runs q with the full-text index of the database: tsvector
+// on Postgres, FTS5 on SQLite. SQLite builds without the sqlite_fts5 tag
+// have no FTS5 and fall back to matching substrings.
+func (r *Repo) SearchMessages(q SearchQuery) (models.SearchPage, error) {
+	terms := searchTerms(q.Text)
+	if len(terms) == 0 {
+		return models.SearchPage{Results: []models.SearchHit{}}, nil
+	}
+	limit := messageLimit(q.Limit)
+
+	tx := r.DB.Table("messages AS m")
+	switch {
+	case r.driver == DriverPostgres:
+		options := "StartSel=" + snippetStart + ", StopSel=" + snippetEnd + ", MaxWords=24, MinWords=8"
+		tx = tx.Select("m.id, m.room, m.nickname, m.timestamp, ts_headline('simple', m.content, plainto_tsquery('simple', ?), ?) AS snippet", q.Text, options).
+			Where("m.search @@ plainto_tsquery('simple', ?)", q.Text)
+	case r.fts5:
+		tx = tx.Select("m.id, m.room, m.nickname, m.timestamp, snippet(messages_fts, 0, char(2), char(3), '…', 24) AS snippet").
+			Joins("JOIN messages_fts ON messages_fts.rowid = m.rowid").
+			Where("messages_fts MATCH ?", ftsQuery(terms))
+	default:
+		tx = tx.Select("m.id, m.room, m.nickname, m.timestamp, m.content AS snippet")
+		for _, term := range terms {
+			tx = tx.Where(`m.content LIKE ? ESCAPE '\'`, "%"+escapeLike(term)+"%")
+		}
+	}
+	tx = filterSearch(tx, q)
+
+	hits := []models.SearchHit{}
+	if err := tx.Order("m.id DESC").Limit(limit + 1).Scan(&hits).Error; err != nil {
+		return models.SearchPage{}, err
+	}
+	page := models.SearchPage{HasMore: len(hits) > limit}
+	if page.HasMore {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		if r.driver != DriverPostgres && !r.fts5 {
+			hits[i].Snippet = highlight(hits[i].Snippet, terms)
+		}
+		hits[i].Snippet = markSnippet(hits[i].Snippet)
+	}
+	page.Results = hits
+	return page, nil
+}
+
+func filterSearch(tx *gorm.DB, q SearchQuery) *gorm.DB {
+	if q.Room != "" {
+		tx = tx.Where("m.room = ?", q.Room)
+	}
+	if q.Author != "" {
+		tx = tx.Where("m.nickname = ?", q.Author)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("m.timestamp >= ?", q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("m.timestamp < ?", q.To.UTC())
+	}
+	if q.Before != "" {
+		tx = tx.Where("m.id < ?", q.Before)
+	}
+	return tx
+}
+
+// syncSearchIndex keeps the FTS5 index of SQLite messages, kept up to date
+// by triggers, in step with the schema. It is derived data rather than a
+// migration since FTS5 depends on the build: builds without it drop the
+// triggers, and the index is rebuilt whenever they are missing.
+func (r *Repo) syncSearchIndex() error {
+	if r.driver != DriverSQLite {
+		return nil
+	}
+	if !r.DB.Migrator().HasTable("messages") || !r.fts5 {
+		return r.DB.Exec(`
+			DROP TRIGGER IF EXISTS messages_fts_insert;
+			DROP TRIGGER IF EXISTS messages_fts_delete;
+			DROP TRIGGER IF EXISTS messages_fts_update;`).Error
+	}
+
+	var triggers int64
+	err := r.DB.Table("sqlite_master").
+		Where("type = 'trigger' AND name IN ?", []string{"messages_fts_insert", "messages_fts_delete", "messages_fts_update"}).
+		Count(&triggers).Error
+	if err != nil || triggers == 3 {
+		return err
+	}
+	return r.DB.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+			content, content='messages', content_rowid='rowid', tokenize='unicode61 remove_diacritics 2'
+		);
+		CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts(rowid, content) VALUES (new.rowid, new.content);
+		END;
+		CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+		END;
+		CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+			INSERT INTO messages_fts(rowid, content) VALUES (new.rowid, new.content);
+		END;
+		INSERT INTO messages_fts(messages_fts) VALUES ('rebuild');`).Error
+}
+
+// hasFTS5 reports whether the SQLite library was built with FTS5.
+func hasFTS5(db *gorm.DB) bool {
+	var enabled bool
+	err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error
+	return err == nil && enabled
+}
+
+// searchTerms splits a search into the words every hit must contain.
+func searchTerms(text string) []string {
+	return strings.Fields(text)
+}
+
+// ftsQuery quotes every term so FTS5 operators in a search are taken
+// literally, and lets them match as word prefixes.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+// highlight cuts a snippet out of content around the first term and wraps
+// the terms in snippet markers, matching them regardless of case.
+func highlight(content string, terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	if utf8.RuneCountInString(content) > snippetRunes {
+		runes := []rune(content)
+		start := 0
+		if loc := re.FindStringIndex(content); loc != nil {
+			start = max(0, utf8.RuneCountInString(content[:loc[0]])-snippetRunes/4)
+		}
+		end := min(len(runes), start+snippetRunes)
+		excerpt := string(runes[start:end])
+		if start > 0 {
+			excerpt = "…" + excerpt
+		}
+		if end < len(runes) {
+			excerpt += "…"
+		}
+		content = excerpt
+	}
+	return re.ReplaceAllString(content, snippetStart+"$0"+snippetEnd)
+}
+
+// markSnippet HTML-escapes snippet and turns its markers into <mark> tags.
+func markSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	return strings.NewReplacer(snippetStart, "<mark>", snippetEnd, "</mark>").Replace(escaped)
+}
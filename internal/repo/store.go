@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"chat-app/internal/models"
+	"chat-app/pkg/queue"
+	"time"
+)
+
+// Store is the storage the controller depends on. Repo implements it on a
+// GORM database and MemoryStore in memory; storetest.Run checks that an
+// implementation behaves like them.
+type Store interface {
+	RoomStore
+	MessageStore
+	UserStore
+	TaskStore
+
+	// Close releases the storage once the pending writes are done.
+	Close() error
+}
+
+type RoomStore interface {
+	// AddRoom stores a room unless it already exists.
+	AddRoom(name string) error
+	GetRooms() ([]*models.Room, error)
+	GetRoom(name string) (*models.Room, bool, error)
+	// GetRoomStats returns every stored room with its message count and the
+	// time of its latest message, leaving Users to the caller.
+	GetRoomStats() ([]models.UIRoom, error)
+	// SetRoomRetention stores the retention of a room, reporting false when
+	// the room does not exist.
+	SetRoomRetention(name string, retention models.RoomRetention) (bool, error)
+}
+
+type MessageStore interface {
+	// AddMessage stores msg and reports whether it was created. A message
+	// whose ClientKey was already used by the same nickname in the room is
+	// not stored again; the original message is returned instead.
+	AddMessage(msg models.Message) (models.Message, bool, error)
+	// GetMessages returns the latest room messages.
+	GetMessages(room string) ([]models.Message, error)
+	ListMessages(room string, q MessageQuery) (models.MessagePage, error)
+	GetMessage(room, id string) (models.Message, bool, error)
+	// EditMessage replaces the content of a room message at the given time,
+	// keeping the previous content as a revision, and reports false when the
+	// message does not exist.
+	EditMessage(room, id, content string, at time.Time) (models.Message, bool, error)
+	// ListRevisions returns the previous contents of a message, oldest first.
+	ListRevisions(messageID string) ([]models.MessageRevision, error)
+	// EachMessage calls fn with every room message in ascending ID order,
+	// without holding the whole history in memory. An error returned by fn
+	// stops the iteration and is returned.
+	EachMessage(room string, fn func(models.Message) error) error
+	// SearchMessages returns the messages containing every word of q.Text,
+	// newest first, with highlighted snippets.
+	SearchMessages(q SearchQuery) (models.SearchPage, error)
+	// PruneMessages deletes up to limit of the oldest room messages that
+	// policy expires at now, returning how many were deleted.
+	PruneMessages(room string, policy models.RetentionPolicy, now time.Time, limit int) (int64, error)
+}
+
+type UserStore interface {
+	// AddUser stores user, failing with ErrUsernameTaken when its username
+	// is already registered.
+	AddUser(user models.User) (models.User, error)
+	GetUser(username string) (models.User, bool, error)
+	GetUserByID(id string) (models.User, bool, error)
+
+	AddSession(session models.Session) error
+	// GetSession returns the session of an access token hash.
+	GetSession(tokenHash string) (models.Session, bool, error)
+	// GetSessionByRefresh returns the session of a refresh token hash.
+	GetSessionByRefresh(refreshHash string) (models.Session, bool, error)
+	// RotateSession replaces the tokens of the session still holding
+	// refreshHash with the ones in next, reporting false when another
+	// refresh already rotated them.
+	RotateSession(refreshHash string, next models.Session) (bool, error)
+	DeleteSession(tokenHash string) error
+}
+
+// TaskStore persists the durable tasks of the workers.
+type TaskStore interface {
+	queue.Store
+	// ListTasks returns the tasks in the given status across workers, oldest
+	// first.
+	ListTasks(status queue.TaskStatus) ([]queue.Record, error)
+}
+
+var (
+	_ Store = (*Repo)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
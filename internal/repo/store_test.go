@@ -0,0 +1,51 @@
+package repo_test
+
+import (
+	"chat-app/internal/repo"
+	"chat-app/internal/repo/storetest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repo.Store {
+		return repo.NewMemoryStore()
+	})
+}
+
+func TestRepoSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repo.Store {
+		return openRepo(t, ":memory:")
+	})
+}
+
+func TestRepoSQLiteFile(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repo.Store {
+		return openRepo(t, filepath.Join(t.TempDir(), "chat.db"))
+	})
+}
+
+// TestRepoPostgres runs against the database at REPO_TEST_POSTGRES_DSN,
+// reverting its migrations first.
+func TestRepoPostgres(t *testing.T) {
+	dsn := os.Getenv("REPO_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("REPO_TEST_POSTGRES_DSN is not set")
+	}
+	storetest.Run(t, func(t *testing.T) repo.Store {
+		return openRepo(t, dsn, repo.WithDriver(repo.DriverPostgres))
+	})
+}
+
+// openRepo opens an empty database: a shared one keeps the rows of previous
+// runs, so its migrations are reverted and applied again.
+func openRepo(t *testing.T, dsn string, opts ...repo.Option) *repo.Repo {
+	r, err := repo.NewRepo(dsn, opts...)
+	require.NoError(t, err)
+	require.NoError(t, r.MigrateTo(0))
+	require.NoError(t, r.Migrate())
+	return r
+}
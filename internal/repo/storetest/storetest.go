@@ -0,0 +1,509 @@
+// Package storetest is the conformance suite of the repo.Store
+// implementations: every one of them must pass it.
+package storetest
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/repo"
+	"chat-app/pkg/queue"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+var (
+	testRoom = "testroom"
+)
+
+// StoreSuite runs against the empty store returned by Open; its tests
+// build on each other, in order.
+type StoreSuite struct {
+	suite.Suite
+	Open  func(t *testing.T) repo.Store
+	store repo.Store
+}
+
+// Run runs the conformance suite against the empty store returned by open.
+func Run(t *testing.T, open func(t *testing.T) repo.Store) {
+	suite.Run(t, &StoreSuite{Open: open})
+}
+
+func (suite *StoreSuite) SetupSuite() {
+	suite.store = suite.Open(suite.T())
+}
+
+func (suite *StoreSuite) TearDownSuite() {
+	suite.NoError(suite.store.Close())
+}
+
+func (suite *StoreSuite) Test1Room() {
+	err := suite.store.AddRoom(testRoom)
+	suite.NoError(err)
+	suite.NoError(suite.store.AddRoom(testRoom), "adding a room twice is a no-op")
+
+	rooms, err := suite.store.GetRooms()
+	roomsMap := models.ToMap(rooms)
+	suite.NoError(err)
+	testRoom, found := roomsMap[testRoom]
+	suite.True(found)
+	suite.Equal(testRoom.ID, testRoom.ID)
+	suite.Len(rooms, 1)
+}
+
+func (suite *StoreSuite) Test2Message() {
+	msg := models.Message{
+		Room:     testRoom,
+		Nickname: "user1",
+		Content:  "Hello, world!",
+	}
+	stored, created, err := suite.store.AddMessage(msg)
+	suite.NoError(err)
+	suite.True(created)
+	suite.NotEmpty(stored.ID)
+
+	messages, err := suite.store.GetMessages(testRoom)
+	suite.NoError(err)
+	suite.Len(messages, 1)
+	suite.Equal(msg.Content, messages[0].Content)
+	suite.Equal(stored.ID, messages[0].ID)
+}
+
+func (suite *StoreSuite) Test3IdempotentMessage() {
+	room := "idempotent"
+	msg := models.Message{
+		Room:      room,
+		Nickname:  "user1",
+		Content:   "only once",
+		ClientKey: "key-1",
+	}
+	first, created, err := suite.store.AddMessage(msg)
+	suite.NoError(err)
+	suite.True(created)
+
+	retry, created, err := suite.store.AddMessage(msg)
+	suite.NoError(err)
+	suite.False(created)
+	suite.Equal(first.ID, retry.ID)
+
+	msg.Nickname = "user2"
+	_, created, err = suite.store.AddMessage(msg)
+	suite.NoError(err)
+	suite.True(created, "keys are scoped to the sender")
+
+	_, created, err = suite.store.AddMessage(models.Message{Room: room, Nickname: "user1", Content: "no key"})
+	suite.NoError(err)
+	suite.True(created)
+	_, created, err = suite.store.AddMessage(models.Message{Room: room, Nickname: "user1", Content: "no key"})
+	suite.NoError(err)
+	suite.True(created, "messages without a key are never deduplicated")
+
+	messages, err := suite.store.GetMessages(room)
+	suite.NoError(err)
+	suite.Len(messages, 4)
+}
+
+func (suite *StoreSuite) Test4ListMessages() {
+	room := "paged"
+	ids := []string{}
+	for i := 1; i <= 5; i++ {
+		msg, _, err := suite.store.AddMessage(models.Message{Room: room, Nickname: "user1", Content: fmt.Sprintf("m%d", i)})
+		suite.NoError(err)
+		ids = append(ids, msg.ID)
+	}
+	contents := func(page models.MessagePage) []string {
+		c := []string{}
+		for _, msg := range page.Messages {
+			c = append(c, msg.Content)
+		}
+		return c
+	}
+
+	page, err := suite.store.ListMessages(room, repo.MessageQuery{Limit: 2})
+	suite.NoError(err)
+	suite.Equal([]string{"m4", "m5"}, contents(page))
+	suite.True(page.HasMore)
+
+	page, err = suite.store.ListMessages(room, repo.MessageQuery{Before: ids[3], Limit: 2})
+	suite.NoError(err)
+	suite.Equal([]string{"m2", "m3"}, contents(page))
+	suite.True(page.HasMore)
+
+	page, err = suite.store.ListMessages(room, repo.MessageQuery{Before: ids[1], Limit: 2})
+	suite.NoError(err)
+	suite.Equal([]string{"m1"}, contents(page))
+	suite.False(page.HasMore)
+
+	page, err = suite.store.ListMessages(room, repo.MessageQuery{After: ids[1], Limit: 2})
+	suite.NoError(err)
+	suite.Equal([]string{"m3", "m4"}, contents(page))
+	suite.True(page.HasMore)
+
+	page, err = suite.store.ListMessages(room, repo.MessageQuery{After: ids[2], Before: ids[4]})
+	suite.NoError(err)
+	suite.Equal([]string{"m4"}, contents(page))
+	suite.False(page.HasMore)
+}
+
+func (suite *StoreSuite) Test5Users() {
+	user, err := suite.store.AddUser(models.User{Username: "alice", PasswordHash: "hash"})
+	suite.NoError(err)
+	suite.NotEmpty(user.ID)
+
+	_, err = suite.store.AddUser(models.User{Username: "alice", PasswordHash: "other"})
+	suite.ErrorIs(err, repo.ErrUsernameTaken)
+
+	found, ok, err := suite.store.GetUser("alice")
+	suite.NoError(err)
+	suite.True(ok)
+	suite.Equal(user.ID, found.ID)
+	_, ok, err = suite.store.GetUser("bob")
+	suite.NoError(err)
+	suite.False(ok)
+
+	suite.NoError(suite.store.AddSession(models.Session{TokenHash: "digest", RefreshHash: "refresh", UserID: user.ID, Nickname: user.Username}))
+	session, ok, err := suite.store.GetSession("digest")
+	suite.NoError(err)
+	suite.True(ok)
+	suite.Equal("alice", session.Nickname)
+	_, ok, err = suite.store.GetSession("unknown")
+	suite.NoError(err)
+	suite.False(ok)
+
+	rotated, err := suite.store.RotateSession("refresh", models.Session{TokenHash: "digest2", RefreshHash: "refresh2"})
+	suite.NoError(err)
+	suite.True(rotated)
+	rotated, err = suite.store.RotateSession("refresh", models.Session{TokenHash: "digest3", RefreshHash: "refresh3"})
+	suite.NoError(err)
+	suite.False(rotated)
+	rotatedSession, ok, err := suite.store.GetSessionByRefresh("refresh2")
+	suite.NoError(err)
+	suite.True(ok)
+	suite.Equal(session.ID, rotatedSession.ID)
+
+	suite.NoError(suite.store.DeleteSession("digest2"))
+	_, ok, err = suite.store.GetSession("digest2")
+	suite.NoError(err)
+	suite.False(ok)
+}
+
+func (suite *StoreSuite) Test5RoomStats() {
+	suite.NoError(suite.store.AddRoom("quiet"))
+	stats, err := suite.store.GetRoomStats()
+	suite.NoError(err)
+	byRoom := map[string]models.UIRoom{}
+	for _, room := range stats {
+		byRoom[room.Room] = room
+	}
+
+	suite.Require().Contains(byRoom, "quiet")
+	suite.Zero(byRoom["quiet"].Messages)
+	suite.Nil(byRoom["quiet"].LastActivity)
+
+	latest, err := suite.store.GetMessages(testRoom)
+	suite.NoError(err)
+	page, err := suite.store.ListMessages(testRoom, repo.MessageQuery{Limit: repo.MaxMessageLimit})
+	suite.NoError(err)
+	suite.Require().Contains(byRoom, testRoom)
+	suite.Equal(int64(len(page.Messages)), byRoom[testRoom].Messages)
+	suite.Require().NotNil(byRoom[testRoom].LastActivity)
+	suite.True(latest[len(latest)-1].Timestamp.Equal(*byRoom[testRoom].LastActivity))
+}
+
+func (suite *StoreSuite) Test6Tasks() {
+	now := time.Now().UTC()
+	for i, id := range []string{"t1", "t2", "t3"} {
+		suite.NoError(suite.store.SaveTask(queue.Record{
+			ID:        id,
+			Worker:    "bot",
+			Kind:      "bot_command",
+			Payload:   []byte(`{}`),
+			Status:    queue.TaskPending,
+			CreatedAt: now.Add(time.Duration(i) * time.Second),
+		}))
+	}
+	suite.Error(suite.store.SaveTask(queue.Record{ID: "t1", Worker: "bot"}), "task IDs are unique")
+
+	suite.NoError(suite.store.DeleteTask("t1"))
+	suite.NoError(suite.store.UpdateTask(queue.Record{ID: "t2", Status: queue.TaskFailed, Attempts: 2, LastError: "boom", UpdatedAt: now}))
+
+	pending, err := suite.store.PendingTasks("bot")
+	suite.NoError(err)
+	suite.Require().Len(pending, 1)
+	suite.Equal("t3", pending[0].ID)
+	suite.Equal([]byte(`{}`), pending[0].Payload)
+	pending, err = suite.store.PendingTasks("other")
+	suite.NoError(err)
+	suite.Empty(pending)
+
+	failed, err := suite.store.ListTasks(queue.TaskFailed)
+	suite.NoError(err)
+	suite.Require().Len(failed, 1)
+	suite.Equal("t2", failed[0].ID)
+	suite.Equal(2, failed[0].Attempts)
+	suite.Equal("boom", failed[0].LastError)
+	suite.Equal("bot_command", failed[0].Kind, "updates keep the task kind")
+}
+
+func (suite *StoreSuite) Test7Lookups() {
+	user, found, err := suite.store.GetUser("alice")
+	suite.NoError(err)
+	suite.Require().True(found)
+	byID, found, err := suite.store.GetUserByID(user.ID)
+	suite.NoError(err)
+	suite.True(found)
+	suite.Equal("alice", byID.Username)
+	suite.False(byID.CreatedAt.IsZero(), "the creation time is set")
+	_, found, err = suite.store.GetUserByID("unknown")
+	suite.NoError(err)
+	suite.False(found)
+
+	room := "since"
+	start := time.Now().UTC().Add(-time.Hour)
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		msg, _, err := suite.store.AddMessage(models.Message{
+			Room:      room,
+			Nickname:  "user1",
+			Content:   fmt.Sprintf("m%d", i),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		})
+		suite.NoError(err)
+		ids = append(ids, msg.ID)
+	}
+	page, err := suite.store.ListMessages(room, repo.MessageQuery{Since: start.Add(30 * time.Second)})
+	suite.NoError(err)
+	suite.Require().Len(page.Messages, 2)
+	suite.Equal(ids[1:], []string{page.Messages[0].ID, page.Messages[1].ID})
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	page, err = suite.store.ListMessages(room, repo.MessageQuery{Since: start.Add(90 * time.Second).In(zone)})
+	suite.NoError(err)
+	suite.Require().Len(page.Messages, 1, "a since in another zone is the same instant")
+	suite.Equal(ids[2], page.Messages[0].ID)
+
+	_, _, err = suite.store.AddMessage(models.Message{ID: ids[0], Room: room, Nickname: "user1", Content: "again"})
+	suite.Error(err, "message IDs are unique")
+}
+
+func (suite *StoreSuite) Test8Search() {
+	start := time.Now().UTC().Add(-time.Hour)
+	posts := []models.Message{
+		{Room: "search-a", Nickname: "alice", Content: "Deploy the <b>release</b> tonight"},
+		{Room: "search-a", Nickname: "bob", Content: "the deploy failed"},
+		{Room: "search-b", Nickname: "alice", Content: "we should deploy again tomorrow"},
+		{Room: "search-b", Nickname: "bob", Content: "unrelated chatter"},
+	}
+	ids := []string{}
+	for i, msg := range posts {
+		msg.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		stored, _, err := suite.store.AddMessage(msg)
+		suite.Require().NoError(err)
+		ids = append(ids, stored.ID)
+	}
+	search := func(q repo.SearchQuery) ([]string, models.SearchPage) {
+		page, err := suite.store.SearchMessages(q)
+		suite.Require().NoError(err)
+		hits := []string{}
+		for _, hit := range page.Results {
+			hits = append(hits, hit.ID)
+		}
+		return hits, page
+	}
+
+	hits, page := search(repo.SearchQuery{Text: "DEPLOY"})
+	suite.Equal([]string{ids[2], ids[1], ids[0]}, hits, "newest first, regardless of case")
+	suite.False(page.HasMore)
+	suite.Equal("search-b", page.Results[0].Room)
+	suite.Equal("alice", page.Results[0].Nickname)
+	suite.True(page.Results[0].Timestamp.Equal(start.Add(2 * time.Minute)))
+	suite.Contains(page.Results[1].Snippet, "<mark>deploy</mark>")
+	suite.Contains(page.Results[2].Snippet, "<mark>Deploy</mark>")
+	suite.Contains(page.Results[2].Snippet, "&lt;b&gt;release&lt;/b&gt;", "snippets are HTML-escaped")
+
+	hits, _ = search(repo.SearchQuery{Text: "deploy release"})
+	suite.Equal([]string{ids[0]}, hits, "every word must match")
+	hits, _ = search(repo.SearchQuery{Text: "deploy", Room: "search-a"})
+	suite.Equal([]string{ids[1], ids[0]}, hits)
+	hits, _ = search(repo.SearchQuery{Text: "deploy", Author: "alice"})
+	suite.Equal([]string{ids[2], ids[0]}, hits)
+	hits, _ = search(repo.SearchQuery{Text: "deploy", From: start.Add(time.Minute), To: start.Add(2 * time.Minute)})
+	suite.Equal([]string{ids[1]}, hits, "from is inclusive, to is exclusive")
+
+	hits, page = search(repo.SearchQuery{Text: "deploy", Limit: 2})
+	suite.Equal([]string{ids[2], ids[1]}, hits)
+	suite.True(page.HasMore)
+	hits, page = search(repo.SearchQuery{Text: "deploy", Limit: 2, Before: ids[1]})
+	suite.Equal([]string{ids[0]}, hits)
+	suite.False(page.HasMore)
+
+	hits, _ = search(repo.SearchQuery{Text: `deploy" OR (*`})
+	suite.Empty(hits, "search operators are taken literally")
+	hits, _ = search(repo.SearchQuery{Text: "  "})
+	suite.Empty(hits)
+}
+
+func (suite *StoreSuite) Test9Edits() {
+	room := "edits"
+	posted := time.Now().UTC().Add(-time.Minute).Truncate(time.Millisecond)
+	msg, _, err := suite.store.AddMessage(models.Message{Room: room, Nickname: "user1", Content: "helo wrold", Timestamp: posted, Owner: "owner1"})
+	suite.Require().NoError(err)
+
+	_, found, err := suite.store.GetMessage("elsewhere", msg.ID)
+	suite.NoError(err)
+	suite.False(found, "messages are looked up within their room")
+	stored, found, err := suite.store.GetMessage(room, msg.ID)
+	suite.NoError(err)
+	suite.True(found)
+	suite.Nil(stored.EditedAt)
+	suite.Equal("owner1", stored.Owner)
+
+	_, found, err = suite.store.EditMessage(room, "unknown", "hello", time.Now())
+	suite.NoError(err)
+	suite.False(found)
+
+	firstEdit := posted.Add(10 * time.Second)
+	edited, found, err := suite.store.EditMessage(room, msg.ID, "hello wrold", firstEdit)
+	suite.NoError(err)
+	suite.True(found)
+	suite.Equal("hello wrold", edited.Content)
+	suite.Require().NotNil(edited.EditedAt)
+	secondEdit := posted.Add(20 * time.Second)
+	_, _, err = suite.store.EditMessage(room, msg.ID, "hello world", secondEdit)
+	suite.NoError(err)
+	_, _, err = suite.store.EditMessage(room, msg.ID, "hello world", posted.Add(30*time.Second))
+	suite.NoError(err)
+
+	stored, _, err = suite.store.GetMessage(room, msg.ID)
+	suite.NoError(err)
+	suite.Equal("hello world", stored.Content)
+	suite.Require().NotNil(stored.EditedAt)
+	suite.WithinDuration(secondEdit, *stored.EditedAt, time.Millisecond, "an edit to the same content changes nothing")
+	suite.WithinDuration(posted, stored.Timestamp, time.Millisecond, "the posting time is kept")
+
+	revisions, err := suite.store.ListRevisions(msg.ID)
+	suite.NoError(err)
+	suite.Require().Len(revisions, 2)
+	suite.Equal("helo wrold", revisions[0].Content)
+	suite.WithinDuration(posted, revisions[0].Timestamp, time.Millisecond)
+	suite.Equal("hello wrold", revisions[1].Content)
+	suite.WithinDuration(firstEdit, revisions[1].Timestamp, time.Millisecond)
+
+	page, err := suite.store.SearchMessages(repo.SearchQuery{Text: "world", Room: room})
+	suite.NoError(err)
+	suite.Len(page.Results, 1, "edits are searchable")
+	page, err = suite.store.SearchMessages(repo.SearchQuery{Text: "wrold", Room: room})
+	suite.NoError(err)
+	suite.Empty(page.Results, "replaced contents are not")
+
+	pruned, err := suite.store.PruneMessages(room, models.RetentionPolicy{MaxAge: time.Second}, time.Now(), 10)
+	suite.NoError(err)
+	suite.Equal(int64(1), pruned)
+	revisions, err = suite.store.ListRevisions(msg.ID)
+	suite.NoError(err)
+	suite.Empty(revisions, "revisions are pruned with their message")
+}
+
+func (suite *StoreSuite) Test9Export() {
+	room := "export"
+	_, found, err := suite.store.GetRoom(room)
+	suite.NoError(err)
+	suite.False(found)
+	suite.Require().NoError(suite.store.AddRoom(room))
+	stored, found, err := suite.store.GetRoom(room)
+	suite.NoError(err)
+	suite.Require().True(found)
+	suite.Equal(room, stored.ID)
+
+	ids := []string{}
+	for i := 0; i < repo.DefaultExportBatch+3; i++ {
+		msg, _, err := suite.store.AddMessage(models.Message{Room: room, Nickname: "user1", Content: fmt.Sprintf("m%d", i)})
+		suite.Require().NoError(err)
+		ids = append(ids, msg.ID)
+	}
+	_, _, err = suite.store.AddMessage(models.Message{Room: "elsewhere", Nickname: "user1", Content: "not exported"})
+	suite.Require().NoError(err)
+
+	exported := []string{}
+	err = suite.store.EachMessage(room, func(msg models.Message) error {
+		exported = append(exported, msg.ID)
+		return nil
+	})
+	suite.NoError(err)
+	suite.Equal(ids, exported, "every message is visited once, in ID order, across batches")
+
+	stop := errors.New("stop")
+	visited := 0
+	err = suite.store.EachMessage(room, func(models.Message) error {
+		visited++
+		return stop
+	})
+	suite.ErrorIs(err, stop)
+	suite.Equal(1, visited)
+}
+
+func (suite *StoreSuite) Test9Retention() {
+	room := "retention"
+	suite.Require().NoError(suite.store.AddRoom(room))
+	found, err := suite.store.SetRoomRetention("unknown", models.RoomRetention{LegalHold: true})
+	suite.NoError(err)
+	suite.False(found)
+	retention := models.RoomRetention{Retention: models.RetentionPolicy{MaxAge: time.Hour, MaxCount: 3}, LegalHold: true}
+	found, err = suite.store.SetRoomRetention(room, retention)
+	suite.NoError(err)
+	suite.True(found)
+	rooms, err := suite.store.GetRooms()
+	suite.NoError(err)
+	stored := models.ToMap(rooms)[room]
+	suite.Require().NotNil(stored)
+	suite.Equal(retention.Retention, stored.Retention)
+	suite.True(stored.LegalHold)
+	suite.NoError(suite.store.AddRoom(room), "adding the room again keeps its retention")
+	rooms, err = suite.store.GetRooms()
+	suite.NoError(err)
+	suite.True(models.ToMap(rooms)[room].LegalHold)
+
+	now := time.Now().UTC()
+	ids := []string{}
+	for i := 5; i > 0; i-- {
+		msg, _, err := suite.store.AddMessage(models.Message{
+			Room:      room,
+			Nickname:  "user1",
+			Content:   "ééééé", // 10 bytes
+			Timestamp: now.Add(-time.Duration(i) * time.Hour),
+		})
+		suite.Require().NoError(err)
+		ids = append(ids, msg.ID)
+	}
+	remaining := func() []string {
+		page, err := suite.store.ListMessages(room, repo.MessageQuery{})
+		suite.NoError(err)
+		left := []string{}
+		for _, msg := range page.Messages {
+			left = append(left, msg.ID)
+		}
+		return left
+	}
+	prune := func(policy models.RetentionPolicy, limit int) int64 {
+		pruned, err := suite.store.PruneMessages(room, policy, now, limit)
+		suite.NoError(err)
+		return pruned
+	}
+
+	suite.Zero(prune(models.RetentionPolicy{}, 10), "an empty policy keeps everything")
+	suite.Equal(int64(1), prune(models.RetentionPolicy{MaxCount: 4}, 10))
+	suite.Equal(ids[1:], remaining())
+
+	suite.Equal(int64(1), prune(models.RetentionPolicy{MaxBytes: 25}, 1), "pruning goes in batches")
+	suite.Equal(int64(1), prune(models.RetentionPolicy{MaxBytes: 25}, 1))
+	suite.Zero(prune(models.RetentionPolicy{MaxBytes: 25}, 1), "sizes are counted in bytes")
+	suite.Equal(ids[3:], remaining())
+
+	suite.Equal(int64(1), prune(models.RetentionPolicy{MaxAge: 90 * time.Minute, MaxCount: 10}, 10))
+	suite.Equal(ids[4:], remaining())
+
+	suite.Equal(int64(1), prune(models.RetentionPolicy{MaxBytes: 5}, 10), "a message over the size bound is pruned")
+	suite.Empty(remaining())
+	suite.Zero(prune(models.RetentionPolicy{MaxBytes: 5, MaxCount: 1}, 10))
+}
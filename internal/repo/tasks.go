@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"chat-app/pkg/queue"
+)
+
+func (r *Repo) SaveTask(record queue.Record) error {
+	return r.DB.Create(&record).Error
+}
+
+// UpdateTask saves the outcome of a task attempt.
+func (r *Repo) UpdateTask(record queue.Record) error {
+	return r.DB.Model(&queue.Record{ID: record.ID}).
+		Select("status", "attempts", "last_error", "updated_at").
+		Updates(record).Error
+}
+
+func (r *Repo) DeleteTask(id string) error {
+	return r.DB.Delete(&queue.Record{ID: id}).Error
+}
+
+func (r *Repo) PendingTasks(worker string) ([]queue.Record, error) {
+	var records []queue.Record
+	err := r.DB.Where("worker = ? AND status = ?", worker, queue.TaskPending).
+		Order("created_at, id").
+		Find(&records).Error
+	return records, err
+}
+
+// ListTasks returns the tasks in the given status across workers, oldest first.
+func (r *Repo) ListTasks(status queue.TaskStatus) ([]queue.Record, error) {
+	var records []queue.Record
+	err := r.DB.Where("status = ?", status).Order("created_at, id").Find(&records).Error
+	return records, err
+}
@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"chat-app/internal/models"
+	"chat-app/pkg/utils"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var ErrUsernameTaken = errors.New("username already taken")
+
+func (r *Repo) AddUser(user models.User) (models.User, error) {
+	if user.ID == "" {
+		user.ID = utils.NewID()
+	}
+	err := r.DB.Create(&user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return models.User{}, ErrUsernameTaken
+	}
+	return user, err
+}
+
+func (r *Repo) GetUser(username string) (models.User, bool, error) {
+	return r.getUser("username = ?", username)
+}
+
+func (r *Repo) GetUserByID(id string) (models.User, bool, error) {
+	return r.getUser("id = ?", id)
+}
+
+func (r *Repo) getUser(query string, args ...any) (models.User, bool, error) {
+	var user models.User
+	err := r.DB.Where(query, args...).Take(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.User{}, false, nil
+	}
+	return user, err == nil, err
+}
+
+func (r *Repo) AddSession(session models.Session) error {
+	if session.ID == "" {
+		session.ID = utils.NewID()
+	}
+	return r.DB.Create(&session).Error
+}
+
+// GetSession returns the session of an access token hash.
+func (r *Repo) GetSession(tokenHash string) (models.Session, bool, error) {
+	return r.getSession("token_hash = ?", tokenHash)
+}
+
+// GetSessionByRefresh returns the session of a refresh token hash.
+func (r *Repo) GetSessionByRefresh(refreshHash string) (models.Session, bool, error) {
+	return r.getSession("refresh_hash = ?", refreshHash)
+}
+
+func (r *Repo) getSession(query string, args ...any) (models.Session, bool, error) {
+	var session models.Session
+	err := r.DB.Where(query, args...).Take(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Session{}, false, nil
+	}
+	return session, err == nil, err
+}
+
+// RotateSession replaces the tokens of the session still holding refreshHash
+// with the ones in next, reporting false when another refresh already
+// rotated them.
+func (r *Repo) RotateSession(refreshHash string, next models.Session) (bool, error) {
+	res := r.DB.Model(&models.Session{}).
+		Where("refresh_hash = ?", refreshHash).
+		Updates(map[string]any{
+			"token_hash":         next.TokenHash,
+			"refresh_hash":       next.RefreshHash,
+			"expires_at":         next.ExpiresAt,
+			"refresh_expires_at": next.RefreshExpiresAt,
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *Repo) DeleteSession(tokenHash string) error {
+	return r.DB.Where("token_hash = ?", tokenHash).Delete(&models.Session{}).Error
+}
@@ -0,0 +1,201 @@
+// Package transcript exports the history of a room from a repo.Store as a
+// stream, and imports a JSONL export back, into the same or another store.
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repo"
+	"chat-app/pkg/utils"
+)
+
+// Format is an export format; only FormatJSONL can be imported back.
+type Format string
+
+const (
+	// FormatJSONL writes one JSON message per line.
+	FormatJSONL Format = "jsonl"
+	// FormatText writes one message per line as in the chat log.
+	FormatText Format = "txt"
+	// FormatHTML writes a standalone HTML page.
+	FormatHTML Format = "html"
+)
+
+// ParseFormat returns the format called name, FormatJSONL when name is empty.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(name); format {
+	case "":
+		return FormatJSONL, nil
+	case FormatJSONL, FormatText, FormatHTML:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q", name)
+	}
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatText:
+		return "text/plain; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+var htmlExport = template.Must(template.New("html").Parse(`
+{{- define "head" -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+ol { list-style: none; padding: 0; }
+li { margin: 0.4em 0; white-space: pre-wrap; }
+time { color: #888; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>{{.}}</h1>
+<ol>
+{{end}}
+{{- define "message" -}}
+<li id="{{.ID}}"><time datetime="{{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}}">{{.Timestamp.Format "2006-01-02 15:04:05"}}</time> <b>{{.Nickname}}</b>: {{.Content}}</li>
+{{end}}
+{{- define "foot" -}}
+</ol>
+</body>
+</html>
+{{end}}`))
+
+// Export writes every message of room to w in format, oldest first. The
+// messages are streamed from store, so w may receive part of the export
+// before an error cuts it short.
+func Export(store repo.MessageStore, room string, format Format, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var write func(models.Message) error
+	switch format {
+	case FormatJSONL:
+		enc := json.NewEncoder(bw)
+		write = func(msg models.Message) error { return enc.Encode(msg) }
+	case FormatText:
+		write = func(msg models.Message) error {
+			_, err := fmt.Fprintln(bw, msg.Fmt())
+			return err
+		}
+	case FormatHTML:
+		if err := htmlExport.ExecuteTemplate(bw, "head", room); err != nil {
+			return err
+		}
+		write = func(msg models.Message) error { return htmlExport.ExecuteTemplate(bw, "message", msg) }
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	if err := store.EachMessage(room, write); err != nil {
+		return err
+	}
+	if format == FormatHTML {
+		if err := htmlExport.ExecuteTemplate(bw, "foot", nil); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// maxLine bounds the length of an imported JSONL line.
+const maxLine = 1 << 20
+
+// importKeyPrefix prefixes the ID a message had in its export to make the
+// ClientKey of its import, so importing an export twice stores it once.
+const importKeyPrefix = "import:"
+
+// LineError is the error of Import on a malformed line of the export.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Import stores the messages of the JSONL export read from r in room,
+// creating the room when missing. Authors and timestamps are kept, and every
+// message gets a new ID stamped with its timestamp so it sorts among the
+// room history by when it was posted. Messages an earlier import of the same
+// export stored are skipped. Import stops at the first malformed line with a
+// *LineError, keeping the messages stored until then.
+func Import(store repo.Store, room string, r io.Reader) (models.ImportResult, error) {
+	result := models.ImportResult{Room: room}
+	if room == "" {
+		return result, errors.New("room is required")
+	}
+	if err := store.AddRoom(room); err != nil {
+		return result, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var exported models.Message
+		if err := json.Unmarshal(scanner.Bytes(), &exported); err != nil {
+			return result, &LineError{Line: line, Err: err}
+		}
+		msg, err := importedMessage(room, exported)
+		if err != nil {
+			return result, &LineError{Line: line, Err: err}
+		}
+		if _, created, err := store.AddMessage(msg); err != nil {
+			return result, err
+		} else if created {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+	return result, scanner.Err()
+}
+
+func importedMessage(room string, exported models.Message) (models.Message, error) {
+	switch {
+	case exported.Nickname == "":
+		return models.Message{}, errors.New("nickname is required")
+	case exported.Content == "":
+		return models.Message{}, errors.New("content is required")
+	case exported.Timestamp.IsZero():
+		return models.Message{}, errors.New("timestamp is required")
+	}
+	id, err := utils.NewIDAt(exported.Timestamp)
+	if err != nil {
+		return models.Message{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	msg := models.Message{
+		ID:        id,
+		Room:      room,
+		Nickname:  exported.Nickname,
+		Timestamp: exported.Timestamp.UTC(),
+		Content:   exported.Content,
+		EditedAt:  exported.EditedAt,
+	}
+	if exported.ID != "" {
+		msg.ClientKey = importKeyPrefix + exported.ID
+	}
+	return msg, nil
+}
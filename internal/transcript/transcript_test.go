@@ -0,0 +1,128 @@
+package transcript_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repo"
+	"chat-app/internal/transcript"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TranscriptTestSuite struct {
+	suite.Suite
+	store *repo.MemoryStore
+	start time.Time
+}
+
+func (suite *TranscriptTestSuite) SetupTest() {
+	suite.store = repo.NewMemoryStore()
+	suite.start = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	suite.Require().NoError(suite.store.AddRoom("source"))
+	for i, msg := range []models.Message{
+		{Nickname: "alice", Content: "hello"},
+		{Nickname: "bob", Content: "<b>not bold</b>"},
+		{Nickname: "alice", Content: "bye"},
+	} {
+		msg.Room = "source"
+		msg.Timestamp = suite.start.Add(time.Duration(i) * time.Minute)
+		_, _, err := suite.store.AddMessage(msg)
+		suite.Require().NoError(err)
+	}
+}
+
+func (suite *TranscriptTestSuite) export(format transcript.Format) string {
+	var out bytes.Buffer
+	suite.Require().NoError(transcript.Export(suite.store, "source", format, &out))
+	return out.String()
+}
+
+func (suite *TranscriptTestSuite) Test1Formats() {
+	suite.Equal(
+		"[2024-03-01 09:30:00] alice: hello\n[2024-03-01 09:31:00] bob: <b>not bold</b>\n[2024-03-01 09:32:00] alice: bye\n",
+		suite.export(transcript.FormatText),
+	)
+
+	page := suite.export(transcript.FormatHTML)
+	suite.True(strings.HasPrefix(page, "<!DOCTYPE html>"))
+	suite.Contains(page, "<title>source</title>")
+	suite.Contains(page, "&lt;b&gt;not bold&lt;/b&gt;", "contents are escaped")
+	suite.Equal(3, strings.Count(page, "<li "))
+	suite.True(strings.HasSuffix(page, "</html>\n"))
+
+	suite.Len(strings.Split(strings.TrimSpace(suite.export(transcript.FormatJSONL)), "\n"), 3)
+
+	format, err := transcript.ParseFormat("")
+	suite.NoError(err)
+	suite.Equal(transcript.FormatJSONL, format)
+	_, err = transcript.ParseFormat("pdf")
+	suite.Error(err)
+}
+
+func (suite *TranscriptTestSuite) Test2RoundTrip() {
+	export := suite.export(transcript.FormatJSONL)
+
+	result, err := transcript.Import(suite.store, "copy", strings.NewReader(export))
+	suite.Require().NoError(err)
+	suite.Equal(models.ImportResult{Room: "copy", Imported: 3}, result)
+	_, found, err := suite.store.GetRoom("copy")
+	suite.NoError(err)
+	suite.True(found, "the room is created")
+
+	result, err = transcript.Import(suite.store, "copy", strings.NewReader(export))
+	suite.Require().NoError(err)
+	suite.Equal(models.ImportResult{Room: "copy", Skipped: 3}, result, "importing twice is a no-op")
+
+	source, err := suite.store.GetMessages("source")
+	suite.Require().NoError(err)
+	copied, err := suite.store.GetMessages("copy")
+	suite.Require().NoError(err)
+	suite.Require().Len(copied, len(source))
+	for i, msg := range copied {
+		suite.NotEqual(source[i].ID, msg.ID)
+		suite.Equal("copy", msg.Room)
+		suite.Equal(source[i].Nickname, msg.Nickname)
+		suite.Equal(source[i].Content, msg.Content)
+		suite.True(source[i].Timestamp.Equal(msg.Timestamp))
+	}
+
+	result, err = transcript.Import(suite.store, "copy", strings.NewReader(
+		`{"nickname":"dave","content":"in between","timestamp":"2024-03-01T09:30:30Z"}`,
+	))
+	suite.Require().NoError(err)
+	suite.Equal(1, result.Imported)
+	copied, err = suite.store.GetMessages("copy")
+	suite.Require().NoError(err)
+	suite.Equal("in between", copied[1].Content, "imported messages sort by timestamp")
+}
+
+func (suite *TranscriptTestSuite) Test3MalformedLine() {
+	input := `{"nickname":"alice","content":"ok","timestamp":"2024-03-01T09:30:00Z"}
+
+{"nickname":"alice","timestamp":"2024-03-01T09:31:00Z"}
+{"nickname":"alice","content":"never read","timestamp":"2024-03-01T09:32:00Z"}`
+	result, err := transcript.Import(suite.store, "broken", strings.NewReader(input))
+	var lineErr *transcript.LineError
+	suite.Require().ErrorAs(err, &lineErr)
+	suite.Equal(3, lineErr.Line)
+	suite.Equal(1, result.Imported, "the lines before the malformed one are kept")
+
+	_, err = transcript.Import(suite.store, "broken", strings.NewReader("not json"))
+	suite.ErrorAs(err, &lineErr)
+	suite.Equal(1, lineErr.Line)
+
+	for _, timestamp := range []string{"1969-12-31T23:00:00Z", "0001-01-01T00:00:01Z"} {
+		line := `{"nickname":"alice","content":"out of range","timestamp":"` + timestamp + `"}`
+		_, err = transcript.Import(suite.store, "broken", strings.NewReader(line))
+		suite.Require().ErrorAs(err, &lineErr, timestamp)
+		suite.Equal(1, lineErr.Line)
+	}
+}
+
+func TestTranscriptTestSuite(t *testing.T) {
+	suite.Run(t, new(TranscriptTestSuite))
+}
@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"log"
+	"time"
+)
+
+// DurableTask is a task a Store can persist, so that it survives a restart.
+// TaskID must be unique; Kind selects the Decoder rebuilding it from its Payload.
+type DurableTask interface {
+	Task
+	TaskID() string
+	Kind() string
+	Payload() ([]byte, error)
+}
+
+type TaskStatus string
+
+const (
+	// TaskPending tasks are queued, running or waiting for a retry.
+	TaskPending TaskStatus = "pending"
+	// TaskFailed tasks used up their retries.
+	TaskFailed TaskStatus = "failed"
+)
+
+// Record is the persisted state of a durable task. Completed tasks are
+// deleted, so records are either pending or failed.
+type Record struct {
+	ID        string     `json:"id"         gorm:"primaryKey"`
+	Worker    string     `json:"worker"     gorm:"index:idx_tasks_worker_status,priority:1"`
+	Kind      string     `json:"kind"`
+	Payload   []byte     `json:"payload"`
+	Status    TaskStatus `json:"status"     gorm:"index:idx_tasks_worker_status,priority:2"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func (Record) TableName() string {
+	return "tasks"
+}
+
+// Store persists durable tasks.
+type Store interface {
+	SaveTask(record Record) error
+	UpdateTask(record Record) error
+	DeleteTask(id string) error
+	// PendingTasks returns the pending tasks of worker, oldest first.
+	PendingTasks(worker string) ([]Record, error)
+}
+
+// Decoder rebuilds a durable task from its record, including its attempts.
+type Decoder func(record Record) (DurableTask, error)
+
+// WithStore makes the worker persist its durable tasks in store and resume
+// the pending ones when it starts, rebuilding them with the decoder of their
+// kind. Delivery is at least once: a task that completed right before a
+// crash may run again.
+func WithStore(store Store, decoders map[string]Decoder) WorkerOption {
+	return func(w *Worker) {
+		w.store = store
+		w.decoders = decoders
+	}
+}
+
+// Enqueue queues task, persisting it first when it is durable and the
+// worker has a store. It never blocks: when the queue is full, which a paused
+// worker reaches after DefaultQueueSize tasks, the task is dropped and
+// ErrQueueFull returned.
+func (w *Worker) Enqueue(task Task) error {
+	if durable, ok := task.(DurableTask); ok && w.store != nil {
+		payload, err := durable.Payload()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		err = w.store.SaveTask(Record{
+			ID:        durable.TaskID(),
+			Worker:    w.Name,
+			Kind:      durable.Kind(),
+			Payload:   payload,
+			Status:    TaskPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case w.TaskQueue <- task:
+		return nil
+	default:
+	}
+	if durable, ok := task.(DurableTask); ok && w.store != nil {
+		if err := w.store.DeleteTask(durable.TaskID()); err != nil {
+			log.Printf("error deleting dropped %s task %s: %v", durable.Kind(), durable.TaskID(), err)
+		}
+	}
+	return ErrQueueFull
+}
+
+// resume loads the pending tasks left by a previous run.
+func (w *Worker) resume() []Task {
+	records, err := w.store.PendingTasks(w.Name)
+	if err != nil {
+		log.Printf("error loading %s worker pending tasks: %v", w.Name, err)
+		return nil
+	}
+	tasks := []Task{}
+	for _, record := range records {
+		decode, found := w.decoders[record.Kind]
+		if !found {
+			log.Printf("no decoder for %s task %s of %s worker", record.Kind, record.ID, w.Name)
+			continue
+		}
+		task, err := decode(record)
+		if err != nil {
+			log.Printf("error decoding %s task %s of %s worker: %v", record.Kind, record.ID, w.Name, err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// persist records the outcome of a durable task run; err is nil on success.
+func (w *Worker) persist(task Task, err error, dead bool) {
+	durable, ok := task.(DurableTask)
+	if !ok || w.store == nil {
+		return
+	}
+	if err == nil {
+		err = w.store.DeleteTask(durable.TaskID())
+	} else {
+		payload, perr := durable.Payload()
+		if perr != nil {
+			log.Printf("error encoding %s task %s: %v", durable.Kind(), durable.TaskID(), perr)
+			return
+		}
+		status := TaskPending
+		if dead {
+			status = TaskFailed
+		}
+		err = w.store.UpdateTask(Record{
+			ID:        durable.TaskID(),
+			Worker:    w.Name,
+			Kind:      durable.Kind(),
+			Payload:   payload,
+			Status:    status,
+			Attempts:  task.ExecCount(),
+			LastError: err.Error(),
+			UpdatedAt: time.Now().UTC(),
+		})
+	}
+	if err != nil {
+		log.Printf("error persisting %s task %s state: %v", durable.Kind(), durable.TaskID(), err)
+	}
+}
@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WorkerStats is a snapshot of a worker state and counters. Depth counts the
+// queued tasks and the ones waiting for a retry.
+type WorkerStats struct {
+	Name         string     `json:"name"`
+	Started      bool       `json:"started"`
+	Paused       bool       `json:"paused"`
+	Busy         bool       `json:"busy"`
+	Depth        int        `json:"queue_depth"`
+	Processed    uint64     `json:"processed"`
+	Failed       uint64     `json:"failed"`
+	Retried      uint64     `json:"retried"`
+	DeadLettered uint64     `json:"dead_lettered"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at,omitempty"`
+}
+
+func (w *Worker) Stats() WorkerStats {
+	stats := WorkerStats{
+		Name:         w.Name,
+		Started:      w.started.Load(),
+		Paused:       w.paused.Load(),
+		Busy:         w.busy.Load(),
+		Depth:        w.Depth(),
+		Processed:    w.processed.Load(),
+		Failed:       w.failed.Load(),
+		Retried:      w.retried.Load(),
+		DeadLettered: w.deadLettered.Load(),
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lastError != "" {
+		at := w.lastErrorAt
+		stats.LastError, stats.LastErrorAt = w.lastError, &at
+	}
+	return stats
+}
+
+// Depth returns how many tasks are queued or waiting for a retry.
+func (w *Worker) Depth() int {
+	return len(w.TaskQueue) + int(w.retrying.Load())
+}
+
+// Pause stops the worker from taking new tasks once the running one, if any,
+// is done. Tasks keep queueing until Resume, up to the queue size; past it
+// Enqueue drops them with ErrQueueFull.
+func (w *Worker) Pause() {
+	w.paused.Store(true)
+	w.signal()
+}
+
+func (w *Worker) Resume() {
+	w.paused.Store(false)
+	w.signal()
+}
+
+func (w *Worker) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Drain resumes the worker and waits until the tasks queued so far and the
+// retries they scheduled are done, or until ctx is done. A worker that was
+// never started has nothing to drain.
+func (w *Worker) Drain(ctx context.Context) error {
+	if !w.started.Load() {
+		return nil
+	}
+	w.Resume()
+	for {
+		// the queue is FIFO, so once the barrier runs every task queued
+		// before it has run too
+		b := &barrier{done: make(chan struct{})}
+		select {
+		case w.TaskQueue <- b:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if w.retrying.Load() == 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// barrier is queued by Drain to learn when the tasks ahead of it ran; the
+// worker handles it without running or counting it.
+type barrier struct {
+	done chan struct{}
+}
+
+func (b *barrier) Action(ctx context.Context) error { return nil }
+func (b *barrier) ExecCount() int                   { return 0 }
+func (b *barrier) AddExecCount()                    {}
+func (b *barrier) Log()                             {}
+
+// Pool tracks workers by name.
+type Pool struct {
+	mu      sync.Mutex
+	workers map[string]*Worker
+}
+
+func NewPool() *Pool {
+	return &Pool{workers: map[string]*Worker{}}
+}
+
+func (p *Pool) Add(w *Worker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.workers[w.Name] = w
+}
+
+func (p *Pool) Remove(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.workers, name)
+}
+
+func (p *Pool) Get(name string) (*Worker, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, found := p.workers[name]
+	return w, found
+}
+
+// Stats returns the stats of every worker, sorted by name.
+func (p *Pool) Stats() []WorkerStats {
+	p.mu.Lock()
+	workers := make([]*Worker, 0, len(p.workers))
+	for _, w := range p.workers {
+		workers = append(workers, w)
+	}
+	p.mu.Unlock()
+
+	stats := make([]WorkerStats, 0, len(workers))
+	for _, w := range workers {
+		stats = append(stats, w.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
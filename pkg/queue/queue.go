@@ -2,14 +2,50 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultQueueSize is how many tasks a worker queue holds before Enqueue
+// fails with ErrQueueFull and sends on TaskQueue block.
+const DefaultQueueSize = 256
+
+// ErrQueueFull is returned by Enqueue when the worker queue has no room left.
+var ErrQueueFull = errors.New("queue is full")
+
 type Worker struct {
 	Name      string
 	TaskQueue chan Task
+
+	policy      RetryPolicy
+	deadLetters DeadLetterSink
+	retries     chan Task
+	store       Store
+	decoders    map[string]Decoder
+
+	// wake interrupts the worker loop when it is paused or resumed.
+	wake      chan struct{}
+	stop      chan struct{}
+	stopOnce  sync.Once
+	startOnce sync.Once
+	started   atomic.Bool
+	paused    atomic.Bool
+	busy      atomic.Bool
+	retrying  atomic.Int64
+
+	processed    atomic.Uint64
+	failed       atomic.Uint64
+	retried      atomic.Uint64
+	deadLettered atomic.Uint64
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
 }
 
 type Task interface {
@@ -32,30 +68,147 @@ func init() {
 	}
 }
 
-func NewWorker(name string) *Worker {
-	w := Worker{
+type WorkerOption func(*Worker)
+
+// WithRetryPolicy sets the retry policy of the tasks that have none of their own.
+func WithRetryPolicy(policy RetryPolicy) WorkerOption {
+	return func(w *Worker) {
+		w.policy = policy
+	}
+}
+
+// WithDeadLetters sets where the tasks that used up their retries are recorded.
+func WithDeadLetters(sink DeadLetterSink) WorkerOption {
+	return func(w *Worker) {
+		w.deadLetters = sink
+	}
+}
+
+// WithQueueSize sets how many tasks the worker queue holds.
+func WithQueueSize(size int) WorkerOption {
+	return func(w *Worker) {
+		w.TaskQueue = make(chan Task, size)
+	}
+}
+
+func NewWorker(name string, opts ...WorkerOption) *Worker {
+	w := &Worker{
 		Name:      name,
-		TaskQueue: make(chan Task),
+		TaskQueue: make(chan Task, DefaultQueueSize),
+		policy:    DefaultRetryPolicy(),
+		retries:   make(chan Task),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
-	return &w
+	return w
 }
 
+// StartWorker runs tasks until ctx is done. A failed task is retried after
+// a backoff without holding up the tasks queued behind it, and handed to the
+// dead letter sink once its retry policy gives up.
 func (w *Worker) StartWorker(ctx context.Context) {
 	log.Printf("starting %s worker", w.Name)
+	w.started.Store(true)
+	if w.store != nil {
+		for _, task := range w.resume() {
+			w.run(ctx, task)
+		}
+	}
 	for {
-		select {
-		case task := <-w.TaskQueue:
-			if task.ExecCount() >= ExecutionLimit {
-				task.Log()
+		if w.paused.Load() {
+			select {
+			case <-w.wake:
+			case <-w.stop:
+				defer log.Printf("stopping %s worker", w.Name)
+				return
+			case <-ctx.Done():
+				defer log.Printf("stopping %s worker", w.Name)
 				return
 			}
-			if err := task.Action(ctx); err != nil {
-				task.AddExecCount()
-				w.TaskQueue <- task
-			}
+			continue
+		}
+		select {
+		case task := <-w.TaskQueue:
+			w.run(ctx, task)
+		case task := <-w.retries:
+			w.run(ctx, task)
+			w.retrying.Add(-1)
+		case <-w.wake:
+		case <-w.stop:
+			defer log.Printf("stopping %s worker", w.Name)
+			return
 		case <-ctx.Done():
 			defer log.Printf("stopping %s worker", w.Name)
 			return
 		}
 	}
 }
+
+// Start runs StartWorker in a goroutine unless Start already did, so a
+// worker can be started lazily by whoever needs it first.
+func (w *Worker) Start(ctx context.Context) {
+	w.startOnce.Do(func() { go w.StartWorker(ctx) })
+}
+
+// Stop makes the worker return once its running task, if any, is done; the
+// tasks left in its queue are dropped.
+func (w *Worker) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func (w *Worker) run(ctx context.Context, task Task) {
+	if b, ok := task.(*barrier); ok {
+		close(b.done)
+		return
+	}
+	w.busy.Store(true)
+	defer w.busy.Store(false)
+
+	err := task.Action(ctx)
+	if err == nil {
+		w.processed.Add(1)
+		w.persist(task, nil, false)
+		return
+	}
+	task.AddExecCount()
+	w.failed.Add(1)
+	w.mu.Lock()
+	w.lastError, w.lastErrorAt = err.Error(), time.Now().UTC()
+	w.mu.Unlock()
+
+	policy := w.policy
+	if p, ok := task.(RetryPolicer); ok {
+		policy = p.RetryPolicy()
+	}
+	dead := task.ExecCount() >= policy.MaxAttempts
+	w.persist(task, err, dead)
+	if dead {
+		task.Log()
+		w.deadLettered.Add(1)
+		if w.deadLetters != nil {
+			w.deadLetters.Add(DeadLetter{
+				Worker:   w.Name,
+				Task:     task,
+				Err:      err,
+				Attempts: task.ExecCount(),
+				FailedAt: time.Now().UTC(),
+			})
+		}
+		return
+	}
+
+	w.retried.Add(1)
+	w.retrying.Add(1)
+	time.AfterFunc(policy.Backoff(task.ExecCount()), func() {
+		select {
+		case w.retries <- task:
+		case <-w.stop:
+			w.retrying.Add(-1)
+		case <-ctx.Done():
+			w.retrying.Add(-1)
+		}
+	})
+}
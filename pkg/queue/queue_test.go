@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -30,11 +31,13 @@ func (t *mockTask) AddExecCount() {
 	t.execCount++
 }
 
-func (t mockTask) ExecCount() int {
+func (t *mockTask) ExecCount() int {
+	t.m.Lock()
+	defer t.m.Unlock()
 	return t.execCount
 }
 
-func (t mockTask) Log() {
+func (t *mockTask) Log() {
 	log.Println("mockTask")
 }
 
@@ -88,16 +91,293 @@ func TestWorkerErrorHandling(t *testing.T) {
 
 	taskWithError := &mockTask{m: &sync.Mutex{}}
 	customAction := &customAction{error: fmt.Errorf("task error")}
-	worker := NewWorker("testWorker")
+	deadLetters := NewDeadLetterQueue(10)
+	worker := NewWorker("testWorker", WithDeadLetters(deadLetters))
 	go worker.StartWorker(context.WithValue(context.Background(), customActionKey{}, customAction))
 
 	worker.TaskQueue <- taskWithError
 
 	time.Sleep(1 * time.Second)
 
-	if taskWithError.ExecCount() != 1 {
-		t.Log("Task was not executed")
+	if taskWithError.ExecCount() != ExecutionLimit {
+		t.Log("Task was not retried up to the execution limit")
+		t.FailNow()
+	}
+	if len(deadLetters.Letters()) != 1 {
+		t.Log("Task was not dead-lettered")
+		t.FailNow()
+	}
+
+	// the worker outlives the dead-lettered task
+	worker.TaskQueue <- &mockTask{m: &sync.Mutex{}}
+	time.Sleep(1 * time.Second)
+	if len(deadLetters.Letters()) != 2 {
+		t.Log("Worker stopped after a dead-lettered task")
 		t.FailNow()
 	}
+}
+
+type policyTask struct {
+	*mockTask
+	policy RetryPolicy
+}
+
+func (t policyTask) RetryPolicy() RetryPolicy {
+	return t.policy
+}
+
+func TestTaskRetryPolicy(t *testing.T) {
+	task := policyTask{
+		mockTask: &mockTask{m: &sync.Mutex{}},
+		policy:   RetryPolicy{MaxAttempts: 4, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond},
+	}
+	customAction := &customAction{error: fmt.Errorf("task error")}
+	deadLetters := NewDeadLetterQueue(10)
+	worker := NewWorker("testWorker", WithDeadLetters(deadLetters))
+	go worker.StartWorker(context.WithValue(context.Background(), customActionKey{}, customAction))
+
+	worker.TaskQueue <- task
+
+	time.Sleep(500 * time.Millisecond)
+
+	letters := deadLetters.Letters()
+	if len(letters) != 1 || letters[0].Attempts != 4 || letters[0].Err != customAction.error {
+		t.Logf("Task did not follow its own retry policy: %+v", letters)
+		t.FailNow()
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.5}
+	cases := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+	for _, c := range cases {
+		for i := 0; i < 20; i++ {
+			delay := policy.Backoff(c.attempts)
+			if delay > c.max || delay < c.max/2 {
+				t.Logf("Backoff(%d) = %s, want between %s and %s", c.attempts, delay, c.max/2, c.max)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+type memStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func (s *memStore) SaveTask(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ID] = record
+	return nil
+}
+
+func (s *memStore) UpdateTask(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ID] = record
+	return nil
+}
 
+func (s *memStore) DeleteTask(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+func (s *memStore) PendingTasks(worker string) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := []Record{}
+	for _, record := range s.records {
+		if record.Worker == worker && record.Status == TaskPending {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *memStore) get(id string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, found := s.records[id]
+	return record, found
+}
+
+type durableTask struct {
+	*mockTask
+	id string
+}
+
+func (t durableTask) TaskID() string {
+	return t.id
+}
+
+func (t durableTask) Kind() string {
+	return "mock"
+}
+
+func (t durableTask) Payload() ([]byte, error) {
+	return []byte(t.id), nil
+}
+
+func TestDurableWorker(t *testing.T) {
+	store := &memStore{records: map[string]Record{
+		"left-over": {ID: "left-over", Worker: "durable", Kind: "mock", Status: TaskPending},
+	}}
+	resumed := make(chan string, 1)
+	decoders := map[string]Decoder{
+		"mock": func(record Record) (DurableTask, error) {
+			resumed <- record.ID
+			return durableTask{mockTask: &mockTask{m: &sync.Mutex{}}, id: record.ID}, nil
+		},
+	}
+	worker := NewWorker("durable", WithStore(store, decoders))
+	go worker.StartWorker(context.Background())
+
+	select {
+	case id := <-resumed:
+		if id != "left-over" {
+			t.Log("Unexpected task resumed:", id)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Log("Pending task was not resumed")
+		t.FailNow()
+	}
+
+	if err := worker.Enqueue(durableTask{mockTask: &mockTask{m: &sync.Mutex{}}, id: "done"}); err != nil {
+		t.Log("Error enqueueing task:", err)
+		t.FailNow()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, found := store.get("done"); found {
+		t.Log("Completed task was not removed from the store")
+		t.FailNow()
+	}
+	if _, found := store.get("left-over"); found {
+		t.Log("Resumed task was not removed from the store")
+		t.FailNow()
+	}
+
+	failing := NewWorker("failing", WithStore(store, decoders), WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	go failing.StartWorker(context.WithValue(context.Background(), customActionKey{}, &customAction{error: fmt.Errorf("task error")}))
+	if err := failing.Enqueue(durableTask{mockTask: &mockTask{m: &sync.Mutex{}}, id: "broken"}); err != nil {
+		t.Log("Error enqueueing task:", err)
+		t.FailNow()
+	}
+	time.Sleep(100 * time.Millisecond)
+	record, found := store.get("broken")
+	if !found || record.Status != TaskFailed || record.Attempts != 2 || record.LastError != "task error" {
+		t.Logf("Failed task was not recorded as failed: %+v", record)
+		t.FailNow()
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	pool := NewPool()
+	worker := NewWorker("stats", WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	pool.Add(worker)
+	pool.Add(NewWorker("another"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.StartWorker(ctx)
+
+	worker.Pause()
+	tasks := []*mockTask{}
+	for i := 0; i < 3; i++ {
+		task := &mockTask{m: &sync.Mutex{}}
+		tasks = append(tasks, task)
+		worker.TaskQueue <- task
+	}
+	time.Sleep(50 * time.Millisecond)
+	if stats := worker.Stats(); !stats.Paused || stats.Depth != 3 || stats.Processed != 0 {
+		t.Logf("Paused worker ran tasks: %+v", stats)
+		t.FailNow()
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, time.Second)
+	defer drainCancel()
+	if err := worker.Drain(drainCtx); err != nil {
+		t.Log("Error draining worker:", err)
+		t.FailNow()
+	}
+	for _, task := range tasks {
+		if task.ExecCount() != 1 {
+			t.Log("Drain returned before every task ran")
+			t.FailNow()
+		}
+	}
+
+	stats := pool.Stats()
+	if len(stats) != 2 || stats[0].Name != "another" || stats[1].Name != "stats" {
+		t.Logf("Unexpected pool stats: %+v", stats)
+		t.FailNow()
+	}
+	if s := stats[1]; s.Paused || s.Depth != 0 || s.Processed != 3 || s.Failed != 0 {
+		t.Logf("Unexpected worker stats: %+v", s)
+		t.FailNow()
+	}
+}
+
+func TestWorkerStop(t *testing.T) {
+	worker := NewWorker("stopped")
+	done := make(chan struct{})
+	go func() {
+		worker.StartWorker(context.Background())
+		close(done)
+	}()
+
+	worker.Stop()
+	worker.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Log("Worker did not stop")
+		t.FailNow()
+	}
+}
+
+func TestEnqueueFull(t *testing.T) {
+	store := &memStore{records: map[string]Record{}}
+	// a worker that is not running leaves its queue as full as a paused one
+	worker := NewWorker("full", WithQueueSize(1), WithStore(store, nil))
+
+	if err := worker.Enqueue(durableTask{mockTask: &mockTask{m: &sync.Mutex{}}, id: "queued"}); err != nil {
+		t.Log("Error enqueueing task:", err)
+		t.FailNow()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Enqueue(durableTask{mockTask: &mockTask{m: &sync.Mutex{}}, id: "dropped"})
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrQueueFull) {
+			t.Log("Unexpected error enqueueing past the queue size:", err)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Log("Enqueue blocked on a full queue")
+		t.FailNow()
+	}
+	if _, found := store.get("dropped"); found {
+		t.Log("Dropped task was left pending in the store")
+		t.FailNow()
+	}
+	if _, found := store.get("queued"); !found {
+		t.Log("Queued task was not persisted")
+		t.FailNow()
+	}
 }
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides how often and how soon a failed task is run again.
+// The delay before attempt n+1 is BaseDelay*2^(n-1), capped at MaxDelay, of
+// which up to a Jitter fraction is taken off at random so tasks failing
+// together do not retry in lockstep.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// DefaultRetryPolicy allows ExecutionLimit attempts.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: ExecutionLimit,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+		Jitter:      0.5,
+	}
+}
+
+// Backoff returns the delay before retrying a task that failed attempts times.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.MaxDelay
+	if attempts < 1 {
+		attempts = 1
+	}
+	if shift := attempts - 1; shift < 32 {
+		if d := p.BaseDelay << shift; d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// RetryPolicer is implemented by tasks that need a retry policy of their own
+// instead of the worker's.
+type RetryPolicer interface {
+	RetryPolicy() RetryPolicy
+}
+
+// DeadLetter is a task that failed for good.
+type DeadLetter struct {
+	Worker   string
+	Task     Task
+	Err      error
+	Attempts int
+	FailedAt time.Time
+}
+
+// DeadLetterInfo is a dead letter as listed by the admin API; TaskID and
+// Kind are set for durable tasks only.
+type DeadLetterInfo struct {
+	Worker   string    `json:"worker"`
+	TaskID   string    `json:"task_id,omitempty"`
+	Kind     string    `json:"kind,omitempty"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+func (l DeadLetter) Info() DeadLetterInfo {
+	info := DeadLetterInfo{Worker: l.Worker, Attempts: l.Attempts, FailedAt: l.FailedAt}
+	if durable, ok := l.Task.(DurableTask); ok {
+		info.TaskID, info.Kind = durable.TaskID(), durable.Kind()
+	}
+	if l.Err != nil {
+		info.Error = l.Err.Error()
+	}
+	return info
+}
+
+// DeadLetterSink records tasks that used up their retries.
+type DeadLetterSink interface {
+	Add(letter DeadLetter)
+}
+
+// DeadLetterQueue is an in-memory DeadLetterSink keeping the latest letters.
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	size    int
+	letters []DeadLetter
+}
+
+// NewDeadLetterQueue returns a queue keeping up to size letters.
+func NewDeadLetterQueue(size int) *DeadLetterQueue {
+	return &DeadLetterQueue{size: size}
+}
+
+func (q *DeadLetterQueue) Add(letter DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.letters = append(q.letters, letter)
+	if len(q.letters) > q.size {
+		q.letters = q.letters[len(q.letters)-q.size:]
+	}
+}
+
+// Letters returns the recorded letters, oldest first.
+func (q *DeadLetterQueue) Letters() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	letters := make([]DeadLetter, len(q.letters))
+	copy(letters, q.letters)
+	return letters
+}
@@ -1,17 +1,67 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/oklog/ulid/v2"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func NewSocketConnection(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+// NewID returns a ULID, which sorts lexicographically in creation order.
+func NewID() string {
+	return ulid.Make().String()
+}
+
+// NewIDAt returns a ULID stamped with t instead of the current time, so a
+// message imported from another history sorts by when it was posted. ULIDs
+// only hold times from the Unix epoch up to the year 10889.
+func NewIDAt(t time.Time) (string, error) {
+	if t.Before(time.UnixMilli(0)) {
+		return "", errors.New("time is before 1970")
+	}
+	id, err := ulid.New(ulid.Timestamp(t), ulid.DefaultEntropy())
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// TokenSubprotocolPrefix marks the websocket subprotocol carrying an access
+// token, since browsers cannot set headers on the handshake. It is never
+// selected by the upgrader, so clients must also offer a regular subprotocol.
+const TokenSubprotocolPrefix = "token."
+
+// RequestToken returns the access token of r, taken from a bearer
+// Authorization header, the token query parameter or a token subprotocol.
+func RequestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(protocol, TokenSubprotocolPrefix); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+func NewSocketConnection(w http.ResponseWriter, r *http.Request, subprotocols ...string) (*websocket.Conn, error) {
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
+		Subprotocols: subprotocols,
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -22,3 +72,30 @@ func NewSocketConnection(w http.ResponseWriter, r *http.Request) (*websocket.Con
 	return conn, nil
 
 }
+
+// NewToken returns a random URL-safe token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "error generating token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the digest tokens are stored and looked up by.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.Wrap(err, "error hashing password")
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}